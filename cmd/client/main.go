@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"crypto/sha256"
+	"io"
 	"log/slog"
+	"net"
 	"os"
 
 	"github.com/kelseyhightower/envconfig"
@@ -14,22 +16,41 @@ import (
 
 const appName = "POW"
 
+// solverSha256Midstate selects the SHA-256 midstate solver, it's faster on CPUs without SHA extensions.
+const solverSha256Midstate = "sha256_midstate"
+
 func main() {
 	cfg := new(Config)
 	cfg.fromEnv(appName)
 
-	powSolver := pow.NewChallenger(
+	powChallenger := pow.NewChallenger(
 		pow.NewDifficultyStorage(),
 		pow.NewRandomDataGenerator(sha256.Size),
 		pow.NewSha256Hasher(),
 	)
 
+	var powSolver client.PowChallengeSolver = pow.NewParallelSolver(powChallenger, cfg.SolverWorkers)
+	if cfg.Solver == solverSha256Midstate {
+		powSolver = pow.NewSha256MidstateSolver(powChallenger, cfg.SolverWorkers)
+	}
+
 	ctx := context.Background()
 
 	logHandler := slog.NewTextHandler(os.Stdout, new(slog.HandlerOptions))
 	logger := slog.New(logHandler)
 
 	cli := client.NewClient(cfg.Client, powSolver, logHandler)
+
+	if cfg.Forward != "" {
+		forward(ctx, cli, cfg.Forward, logger)
+		return
+	}
+
+	if cfg.Quotes > 1 {
+		getSessionQuotes(ctx, cli, cfg.Quotes, logger)
+		return
+	}
+
 	res, err := cli.GetWordOfWisdom(ctx)
 	if err != nil {
 		logger.Error("get word of wisdom error", "err", err)
@@ -39,8 +60,74 @@ func main() {
 	logger.Info("got word of wisdom", "res", res)
 }
 
+func getSessionQuotes(ctx context.Context, cli *client.Client, quotes int, logger *slog.Logger) {
+	session, err := cli.NewSession(ctx)
+	if err != nil {
+		logger.Error("open session error", "err", err)
+		os.Exit(1)
+	}
+	defer session.Close()
+
+	for i := 0; i < quotes; i++ {
+		res, err := session.GetWordOfWisdom(ctx)
+		if err != nil {
+			logger.Error("get word of wisdom error", "err", err)
+			os.Exit(1)
+		}
+
+		logger.Info("got word of wisdom", "res", res)
+	}
+}
+
+// forward accepts the local connections and splices every one to the upstream of the server
+// in the reverse proxy mode, the challenge is solved per connection.
+func forward(ctx context.Context, cli *client.Client, addr string, logger *slog.Logger) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Error("listen error", "addr", addr, "err", err)
+		os.Exit(1)
+	}
+	logger.Info("forwarding connections to upstream", "addr", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Error("accept connection error", "err", err)
+			os.Exit(1)
+		}
+
+		go func() {
+			defer conn.Close()
+
+			upstream, err := cli.Dial(ctx)
+			if err != nil {
+				logger.Error("dial upstream error", "err", err)
+				return
+			}
+			defer upstream.Close()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_, _ = io.Copy(conn, upstream)
+				_ = conn.(*net.TCPConn).CloseWrite()
+			}()
+			_, _ = io.Copy(upstream, conn)
+			_ = upstream.(interface{ CloseWrite() error }).CloseWrite()
+			<-done
+		}()
+	}
+}
+
 type Config struct {
 	Client client.Config `envconfig:"CLIENT"`
+	// SolverWorkers is the number of goroutines solving the challenge, GOMAXPROCS by default.
+	SolverWorkers int    `envconfig:"SOLVER_WORKERS"`
+	Solver        string `envconfig:"SOLVER" default:"parallel"`
+	// Quotes is the number of quotes to get, they're got within the session when it's more than 1.
+	Quotes int `envconfig:"QUOTES" default:"1"`
+	// Forward is the local address the connections spliced to the server upstream are accepted on.
+	Forward string `envconfig:"FORWARD"`
 }
 
 func (c *Config) fromEnv(prefix string) {
@@ -4,10 +4,13 @@ import (
 	"context"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/exec"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 
@@ -22,10 +25,72 @@ func main() {
 	cfg := new(Config)
 	cfg.fromEnv(appName)
 
+	var (
+		difficultyGetter pow.DifficultyGetter = pow.NewDifficultyStorage()
+		serverOpts       []server.Option
+		powOpts          []pow.ChallengerOption
+	)
+	adaptiveCfg := cfg.Pow.Adaptive
+	if adaptiveCfg.Enabled {
+		if cfg.Pow.Argon2.Enabled {
+			adaptiveCfg = cfg.Pow.Argon2.AdaptiveConfig(adaptiveCfg)
+		}
+		adaptiveDifficulty := pow.NewAdaptiveDifficulty(adaptiveCfg, time.Now)
+		difficultyGetter = adaptiveDifficulty
+		serverOpts = append(serverOpts, server.WithLoadReporter(adaptiveDifficulty))
+	}
+	if cfg.Pow.Argon2.Enabled {
+		if !cfg.Pow.Adaptive.Enabled {
+			difficultyGetter = pow.NewStaticDifficulty(cfg.Pow.Argon2.Difficulty)
+		}
+		powOpts = append(powOpts, pow.WithArgon2(cfg.Pow.Argon2.Params()))
+	}
+	if cfg.Pow.TargetWork > 0 {
+		var targetGetter pow.TargetGetter = pow.NewStaticTarget(cfg.Pow.TargetWork)
+		// The adaptive difficulty raises the target work by the bits it's raised above its min.
+		if adaptiveCfg.Enabled {
+			targetGetter = pow.NewAdjustedTarget(targetGetter, difficultyGetter, adaptiveCfg.MinDifficulty)
+		}
+		powOpts = append(powOpts, pow.WithTargetMode(targetGetter))
+	}
+	if cfg.Pow.Reputation.Enabled {
+		reputationTracker := pow.NewReputationTracker(cfg.Pow.Reputation, time.Now)
+		powOpts = append(powOpts, pow.WithDifficultyAdjuster(reputationTracker))
+		serverOpts = append(serverOpts, server.WithClientReporter(reputationTracker))
+	}
+	if cfg.Server.TLS.ClientCAFile != "" {
+		powOpts = append(powOpts, pow.WithTrustedClientDifficulty(cfg.Pow.TrustedClientDifficulty))
+	}
+	// The signed challenges and the hashcash stamps are redeemed once.
+	if cfg.Pow.Secret != "" || cfg.Pow.HashcashResource != "" {
+		powOpts = append(powOpts, pow.WithReplayCache(pow.NewShardedReplayCache(cfg.Pow.ReplayCacheSize)))
+	}
+	if cfg.Pow.HashcashResource != "" {
+		powOpts = append(powOpts, pow.WithHashcash(cfg.Pow.HashcashResource, cfg.Pow.HashcashWindow))
+	}
+	if cfg.Pow.Secret != "" {
+		powOpts = append(powOpts, pow.WithHmacSigning([]byte(cfg.Pow.Secret), cfg.Pow.ChallengeTTL))
+	}
+
+	if cfg.Pow.AccessToken.Enabled {
+		if cfg.Pow.Secret == "" {
+			slog.Error("access tokens require the secret")
+			os.Exit(1)
+		}
+		accessTokens := pow.NewAccessTokens(
+			[]byte(cfg.Pow.Secret),
+			cfg.Pow.AccessToken,
+			pow.NewShardedReplayCache(cfg.Pow.ReplayCacheSize),
+			time.Now,
+		)
+		serverOpts = append(serverOpts, server.WithAccessTokens(accessTokens))
+	}
+
 	powChallenger := pow.NewChallenger(
-		pow.NewDifficultyStorage(),
+		difficultyGetter,
 		pow.NewRandomDataGenerator(sha256.Size),
 		pow.NewSha256Hasher(),
+		powOpts...,
 	)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -33,22 +98,39 @@ func main() {
 	logHandler := slog.NewTextHandler(os.Stdout, new(slog.HandlerOptions))
 	logger := slog.New(logHandler)
 
+	quotesStorage := wisdom.NewQuotesStorage()
+
+	srv := server.NewServer(cfg.Server, powChallenger, quotesStorage, logHandler, serverOpts...)
+
 	go func() {
 		sigCh := make(chan os.Signal, 1)
-		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-		select {
-		case s := <-sigCh:
-			logger.Warn("signal received, stopping", "signal", s)
-			cancel()
-		case <-ctx.Done():
-			return
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	waitStop:
+		for {
+			select {
+			case s := <-sigCh:
+				if s != syscall.SIGHUP {
+					logger.Warn("signal received, stopping", "signal", s)
+					break waitStop
+				}
+				if err := restart(srv); err != nil {
+					logger.Error("restart server error", "err", err)
+					continue
+				}
+				logger.Warn("new server process started, stopping", "signal", s)
+				break waitStop
+			case <-ctx.Done():
+				return
+			}
 		}
-	}()
+		cancel()
 
-	quotesStorage := wisdom.NewQuotesStorage()
-
-	srv := server.NewServer(cfg.Server, powChallenger, quotesStorage, logHandler)
+		// The server drains the in-flight connections, the second signal stops it at once.
+		s := <-sigCh
+		logger.Warn("signal received while draining connections, exiting", "signal", s)
+		os.Exit(1)
+	}()
 
 	logger.Info("run server")
 
@@ -56,10 +138,35 @@ func main() {
 		logger.Error("run server error", "err", err)
 		os.Exit(1)
 	}
+
+	if cfg.Server.Upstream != "" {
+		stats := srv.UpstreamStats()
+		logger.Info("upstream connections served", "connections", stats.Connections,
+			"bytes_sent", stats.BytesSent, "bytes_received", stats.BytesReceived)
+	}
+
+	logger.Info("server stopped")
+}
+
+// restart starts the new server process inheriting the listeners, it accepts the connections
+// while this one drains.
+func restart(srv *server.Server) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("get executable error: %w", err)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := srv.StartWithListeners(cmd); err != nil {
+		return fmt.Errorf("start new server process error: %w", err)
+	}
+	return cmd.Process.Release()
 }
 
 type Config struct {
 	Server server.Config `envconfig:"SERVER"`
+	Pow    pow.Config    `envconfig:"POW"`
 }
 
 func (c *Config) fromEnv(prefix string) {
@@ -0,0 +1,116 @@
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAccessTokenRejected is the base error for access tokens which can't be accepted.
+	ErrAccessTokenRejected = fmt.Errorf("%w: access token rejected", ErrChallengeRejected)
+	// ErrInvalidAccessToken is returned for a malformed, forged or another client token.
+	ErrInvalidAccessToken = fmt.Errorf("%w: invalid access token", ErrAccessTokenRejected)
+	// ErrAccessTokenExpired is returned for a token presented after its expiration time.
+	ErrAccessTokenExpired = fmt.Errorf("%w: access token expired", ErrAccessTokenRejected)
+	// ErrAccessTokenUsedUp is returned for a token presented more times than allowed.
+	ErrAccessTokenUsedUp = fmt.Errorf("%w: access token used up", ErrAccessTokenRejected)
+)
+
+type AccessTokenConfig struct {
+	// Enabled makes the server issue access tokens on solved challenges, they require the secret.
+	Enabled bool          `envconfig:"ENABLED"`
+	TTL     time.Duration `envconfig:"TTL" default:"10m"`
+	MaxUses int           `envconfig:"MAX_USES" default:"5"`
+}
+
+const (
+	accessTokenIDSize      = 16
+	accessTokenPayloadSize = accessTokenIDSize + 8 + 1
+	maxAccessTokenUses     = 255
+)
+
+// AccessTokens issues the signed tokens letting the client skip the challenges on a limited
+// number of later connections. The token is bound to the client address and its uses are
+// counted in the replay cache.
+type AccessTokens struct {
+	secret  []byte
+	ttl     time.Duration
+	maxUses int
+	uses    ReplayCache
+	now     func() time.Time
+}
+
+func NewAccessTokens(secret []byte, cfg AccessTokenConfig, uses ReplayCache, now func() time.Time) *AccessTokens {
+	return &AccessTokens{
+		secret:  secret,
+		ttl:     cfg.TTL,
+		maxUses: min(max(cfg.MaxUses, 1), maxAccessTokenUses),
+		uses:    uses,
+		now:     now,
+	}
+}
+
+// IssueToken returns the token for the client and its expiration time.
+func (at *AccessTokens) IssueToken(client ClientInfo) (string, time.Time, error) {
+	payload := make([]byte, accessTokenIDSize, accessTokenPayloadSize)
+	if _, err := rand.Read(payload); err != nil {
+		return "", time.Time{}, fmt.Errorf("read random token id error: %w", err)
+	}
+
+	expiresAt := at.now().Add(at.ttl)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(expiresAt.Unix()))
+	payload = append(payload, byte(at.maxUses))
+
+	token := base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(at.mac(payload, client.Addr))
+
+	return token, time.Unix(expiresAt.Unix(), 0), nil
+}
+
+// CheckToken reports whether the token lets the client skip the challenge and counts its use.
+// Errors wrapping ErrAccessTokenRejected are returned for the tokens which can't be accepted.
+func (at *AccessTokens) CheckToken(token string, client ClientInfo) (bool, error) {
+	encodedPayload, encodedMac, ok := strings.Cut(token, ".")
+	if !ok {
+		return false, ErrInvalidAccessToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) != accessTokenPayloadSize {
+		return false, ErrInvalidAccessToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil || !hmac.Equal(mac, at.mac(payload, client.Addr)) {
+		return false, ErrInvalidAccessToken
+	}
+
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload[accessTokenIDSize:])), 0)
+	if at.now().After(expiresAt) {
+		return false, ErrAccessTokenExpired
+	}
+
+	// Every use is redeemed once, so the token is accepted while an unused one is left.
+	id := hex.EncodeToString(payload[:accessTokenIDSize])
+	for use := 1; use <= int(payload[accessTokenPayloadSize-1]); use++ {
+		if at.uses.MarkRedeemed(id+"/"+strconv.Itoa(use), expiresAt) {
+			return true, nil
+		}
+	}
+
+	return false, ErrAccessTokenUsedUp
+}
+
+func (at *AccessTokens) mac(payload []byte, clientAddr string) []byte {
+	h := hmac.New(sha256.New, at.secret)
+	writeMacField(h, payload)
+	writeMacField(h, []byte(clientAddr))
+	return h.Sum(nil)
+}
@@ -0,0 +1,86 @@
+package pow_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+func TestAccessTokens_CheckToken(t *testing.T) {
+	now := time.Now()
+	accessTokens := pow.NewAccessTokens(
+		[]byte("test_secret"),
+		pow.AccessTokenConfig{TTL: time.Minute, MaxUses: 3},
+		pow.NewShardedReplayCache(100),
+		func() time.Time { return now },
+	)
+
+	token, expiresAt, err := accessTokens.IssueToken(testClient)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(time.Minute).Unix(), expiresAt.Unix())
+
+	for i := 0; i < 3; i++ {
+		ok, err := accessTokens.CheckToken(token, testClient)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	_, err = accessTokens.CheckToken(token, testClient)
+	require.ErrorIs(t, err, pow.ErrAccessTokenUsedUp)
+	require.ErrorIs(t, err, pow.ErrChallengeRejected)
+
+	otherToken, _, err := accessTokens.IssueToken(testClient)
+	require.NoError(t, err)
+	require.NotEqual(t, token, otherToken)
+
+	ok, err := accessTokens.CheckToken(otherToken, testClient)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, err = accessTokens.CheckToken(otherToken, pow.ClientInfo{Addr: "127.0.0.2"})
+	require.ErrorIs(t, err, pow.ErrInvalidAccessToken)
+
+	now = now.Add(2 * time.Minute)
+	_, err = accessTokens.CheckToken(otherToken, testClient)
+	require.ErrorIs(t, err, pow.ErrAccessTokenExpired)
+}
+
+func TestAccessTokens_CheckTokenInvalid(t *testing.T) {
+	accessTokens := pow.NewAccessTokens(
+		[]byte("test_secret"),
+		pow.AccessTokenConfig{TTL: time.Minute, MaxUses: 3},
+		pow.NewShardedReplayCache(100),
+		time.Now,
+	)
+
+	token, _, err := accessTokens.IssueToken(testClient)
+	require.NoError(t, err)
+
+	payload, mac, _ := strings.Cut(token, ".")
+
+	forgingTokens := pow.NewAccessTokens(
+		[]byte("another_secret"),
+		pow.AccessTokenConfig{TTL: time.Minute, MaxUses: 255},
+		pow.NewShardedReplayCache(100),
+		time.Now,
+	)
+	forgedToken, _, err := forgingTokens.IssueToken(testClient)
+	require.NoError(t, err)
+
+	for _, invalidToken := range []string{
+		"",
+		payload,
+		payload + ".",
+		payload[1:] + "." + mac,
+		payload + "." + mac[1:],
+		"!" + payload[1:] + "." + mac,
+		forgedToken,
+	} {
+		_, err := accessTokens.CheckToken(invalidToken, testClient)
+		require.ErrorIs(t, err, pow.ErrInvalidAccessToken, invalidToken)
+	}
+}
@@ -0,0 +1,109 @@
+package pow
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type AdaptiveDifficultyConfig struct {
+	Enabled       bool `envconfig:"ENABLED"`
+	MinDifficulty int  `envconfig:"MIN" default:"20"`
+	MaxDifficulty int  `envconfig:"MAX" default:"32"`
+	// MaxInFlight, MaxAcceptRate and MaxFailureRate are the signal values treated as the full load.
+	MaxInFlight    int     `envconfig:"MAX_IN_FLIGHT" default:"1000"`
+	MaxAcceptRate  float64 `envconfig:"MAX_ACCEPT_RATE" default:"500"`
+	MaxFailureRate float64 `envconfig:"MAX_FAILURE_RATE" default:"50"`
+	// Difficulty is raised when the load reaches RaiseThreshold and lowered when it falls
+	// to LowerThreshold, the gap between them prevents flapping.
+	RaiseThreshold float64 `envconfig:"RAISE_THRESHOLD" default:"1"`
+	LowerThreshold float64 `envconfig:"LOWER_THRESHOLD" default:"0.5"`
+	// Smoothing is the weight of the latest load sample when the load decreases,
+	// the load increase is applied immediately.
+	Smoothing      float64       `envconfig:"SMOOTHING" default:"0.3"`
+	AdjustInterval time.Duration `envconfig:"ADJUST_INTERVAL" default:"1s"`
+	RaiseStep      int           `envconfig:"RAISE_STEP" default:"2"`
+	LowerStep      int           `envconfig:"LOWER_STEP" default:"1"`
+	// LowerCooldown is the min time between the last difficulty change and lowering it.
+	LowerCooldown time.Duration `envconfig:"LOWER_COOLDOWN" default:"10s"`
+}
+
+// AdaptiveDifficulty is the DifficultyGetter driven by the server load signals:
+// in-flight connections, accept rate and verification failures rate.
+type AdaptiveDifficulty struct {
+	cfg AdaptiveDifficultyConfig
+	now func() time.Time
+
+	inFlight atomic.Int64
+	accepted atomic.Int64
+	failures atomic.Int64
+
+	mu         sync.Mutex
+	difficulty int
+	load       float64
+	lastAdjust time.Time
+	lastChange time.Time
+}
+
+func NewAdaptiveDifficulty(cfg AdaptiveDifficultyConfig, now func() time.Time) *AdaptiveDifficulty {
+	started := now()
+	return &AdaptiveDifficulty{
+		cfg:        cfg,
+		now:        now,
+		difficulty: cfg.MinDifficulty,
+		lastAdjust: started,
+		lastChange: started,
+	}
+}
+
+func (ad *AdaptiveDifficulty) ConnectionAccepted() {
+	ad.inFlight.Add(1)
+	ad.accepted.Add(1)
+}
+
+func (ad *AdaptiveDifficulty) ConnectionClosed() {
+	ad.inFlight.Add(-1)
+}
+
+func (ad *AdaptiveDifficulty) VerificationFailed() {
+	ad.failures.Add(1)
+}
+
+func (ad *AdaptiveDifficulty) GetDifficulty() int {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+
+	now := ad.now()
+	if elapsed := now.Sub(ad.lastAdjust); elapsed >= ad.cfg.AdjustInterval {
+		ad.adjust(now, elapsed)
+	}
+
+	return ad.difficulty
+}
+
+func (ad *AdaptiveDifficulty) adjust(now time.Time, elapsed time.Duration) {
+	ad.lastAdjust = now
+
+	seconds := elapsed.Seconds()
+	load := max(
+		float64(ad.inFlight.Load())/float64(ad.cfg.MaxInFlight),
+		float64(ad.accepted.Swap(0))/seconds/ad.cfg.MaxAcceptRate,
+		float64(ad.failures.Swap(0))/seconds/ad.cfg.MaxFailureRate,
+	)
+
+	if load > ad.load {
+		ad.load = load
+	} else {
+		ad.load = ad.cfg.Smoothing*load + (1-ad.cfg.Smoothing)*ad.load
+	}
+
+	switch {
+	case ad.load >= ad.cfg.RaiseThreshold && ad.difficulty < ad.cfg.MaxDifficulty:
+		ad.difficulty = min(ad.difficulty+ad.cfg.RaiseStep, ad.cfg.MaxDifficulty)
+		ad.lastChange = now
+	case ad.load <= ad.cfg.LowerThreshold && ad.difficulty > ad.cfg.MinDifficulty &&
+		now.Sub(ad.lastChange) >= ad.cfg.LowerCooldown:
+		ad.difficulty = max(ad.difficulty-ad.cfg.LowerStep, ad.cfg.MinDifficulty)
+		ad.lastChange = now
+	}
+}
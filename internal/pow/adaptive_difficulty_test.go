@@ -0,0 +1,86 @@
+package pow_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+func TestAdaptiveDifficulty(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ad := pow.NewAdaptiveDifficulty(pow.AdaptiveDifficultyConfig{
+		MinDifficulty:  10,
+		MaxDifficulty:  16,
+		MaxInFlight:    10,
+		MaxAcceptRate:  100,
+		MaxFailureRate: 10,
+		RaiseThreshold: 1,
+		LowerThreshold: 0.5,
+		Smoothing:      0.5,
+		AdjustInterval: time.Second,
+		RaiseStep:      2,
+		LowerStep:      1,
+		LowerCooldown:  5 * time.Second,
+	}, func() time.Time { return now })
+
+	tick := func() int {
+		now = now.Add(time.Second)
+		return ad.GetDifficulty()
+	}
+
+	require.Equal(t, 10, ad.GetDifficulty())
+
+	t.Run("not_adjusted_within_interval", func(t *testing.T) {
+		for i := 0; i < 1000; i++ {
+			ad.ConnectionAccepted()
+			ad.ConnectionClosed()
+		}
+		require.Equal(t, 10, ad.GetDifficulty())
+	})
+
+	t.Run("accept_rate_raises_immediately", func(t *testing.T) {
+		require.Equal(t, 12, tick())
+	})
+
+	t.Run("in_flight_raises_up_to_max", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			ad.ConnectionAccepted()
+		}
+		require.Equal(t, 14, tick())
+		require.Equal(t, 16, tick())
+		require.Equal(t, 16, tick())
+
+		for i := 0; i < 10; i++ {
+			ad.ConnectionClosed()
+		}
+	})
+
+	t.Run("load_decays_with_smoothing_and_cooldown", func(t *testing.T) {
+		// smoothed load: 0.5, 0.25, ... lowered after the cooldown only
+		for i := 0; i < 3; i++ {
+			require.Equal(t, 16, tick())
+		}
+		require.Equal(t, 15, tick())
+		require.Equal(t, 15, tick())
+	})
+
+	t.Run("hysteresis_keeps_difficulty", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			for j := 0; j < 7; j++ {
+				ad.VerificationFailed()
+			}
+			require.Equal(t, 15, tick())
+		}
+	})
+
+	t.Run("decays_to_min", func(t *testing.T) {
+		var difficulty int
+		for i := 0; i < 100; i++ {
+			difficulty = tick()
+		}
+		require.Equal(t, 10, difficulty)
+	})
+}
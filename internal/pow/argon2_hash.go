@@ -0,0 +1,83 @@
+package pow
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argon2HashSize = 32
+	// maxArgon2Memory limits the memory a challenge may require from the solver, KiB.
+	maxArgon2Memory = 1 << 20
+	maxArgon2Iters  = 16
+	// maxArgon2Threads limits the lanes a challenge may require, the solver runs them in parallel.
+	maxArgon2Threads = 16
+)
+
+// argon2Salt is constant, the challenge random data makes every hashed input unique.
+var argon2Salt = []byte("pow_tcp_server")
+
+type Argon2Config struct {
+	Enabled bool `envconfig:"ENABLED"`
+	// Memory is the memory cost in KiB.
+	Memory     uint32 `envconfig:"MEMORY" default:"8192"`
+	Iterations uint32 `envconfig:"ITERATIONS" default:"1"`
+	Threads    uint8  `envconfig:"THREADS" default:"1"`
+	// Difficulty is the leading zero bits required from the memory-hard hash,
+	// every hash is expensive, so it's much lower than for SHA-256.
+	Difficulty int `envconfig:"DIFFICULTY" default:"6"`
+	// AdaptiveMaxDifficulty is the highest difficulty the adaptive difficulty raises the
+	// Argon2 challenges to, it's raised from Difficulty.
+	AdaptiveMaxDifficulty int `envconfig:"ADAPTIVE_MAX_DIFFICULTY" default:"12"`
+}
+
+// AdaptiveConfig returns the adaptive difficulty config with the Argon2 difficulty bounds, the
+// SHA-256 ones would make the memory-hard challenges unsolvable.
+func (c Argon2Config) AdaptiveConfig(cfg AdaptiveDifficultyConfig) AdaptiveDifficultyConfig {
+	cfg.MinDifficulty, cfg.MaxDifficulty = c.Difficulty, max(c.Difficulty, c.AdaptiveMaxDifficulty)
+	return cfg
+}
+
+func (c Argon2Config) Params() Argon2Params {
+	return Argon2Params{
+		Memory:     c.Memory,
+		Iterations: c.Iterations,
+		Threads:    c.Threads,
+	}
+}
+
+// Argon2Params are the Argon2id parameters carried in the challenge.
+type Argon2Params struct {
+	// Memory is the memory cost in KiB.
+	Memory     uint32 `json:"memory"`
+	Iterations uint32 `json:"iterations"`
+	Threads    uint8  `json:"threads"`
+}
+
+func (p Argon2Params) validate() error {
+	if p.Memory == 0 || p.Memory > maxArgon2Memory {
+		return fmt.Errorf("argon2 memory %v KiB is out of range", p.Memory)
+	}
+	if p.Iterations == 0 || p.Iterations > maxArgon2Iters {
+		return fmt.Errorf("argon2 iterations %v are out of range", p.Iterations)
+	}
+	if p.Threads == 0 || p.Threads > maxArgon2Threads {
+		return fmt.Errorf("argon2 threads %v are out of range", p.Threads)
+	}
+	return nil
+}
+
+// Argon2Hasher is the memory-hard Hasher, it reduces the advantage of ASICs and
+// massively parallel hardware over the regular clients.
+type Argon2Hasher struct {
+	params Argon2Params
+}
+
+func NewArgon2Hasher(params Argon2Params) Argon2Hasher {
+	return Argon2Hasher{params: params}
+}
+
+func (a Argon2Hasher) HashData(data []byte) []byte {
+	return argon2.IDKey(data, argon2Salt, a.params.Iterations, a.params.Memory, a.params.Threads, argon2HashSize)
+}
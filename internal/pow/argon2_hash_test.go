@@ -0,0 +1,138 @@
+package pow_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+var testArgon2Params = pow.Argon2Params{Memory: 64, Iterations: 1, Threads: 1}
+
+func TestChallenger_Argon2(t *testing.T) {
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(4),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+		pow.WithArgon2(testArgon2Params),
+	)
+
+	challenge, err := challenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+	require.Equal(t, &testArgon2Params, challenge.Argon2)
+
+	nonce, err := challenger.SolvePowChallenge(context.Background(), challenge)
+	require.NoError(t, err)
+
+	ok, err := challenger.CheckSolution(challenge, testClient, nonce)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	t.Run("other_params", func(t *testing.T) {
+		other := challenge
+		other.Argon2 = &pow.Argon2Params{Memory: 32, Iterations: 1, Threads: 1}
+		ok, err := challenger.CheckSolution(other, testClient, nonce)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("sha256_challenge", func(t *testing.T) {
+		other := challenge
+		other.Argon2 = nil
+		ok, err := challenger.CheckSolution(other, testClient, nonce)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("sha256_challenger", func(t *testing.T) {
+		sha256Challenger := pow.NewChallenger(
+			pow.NewStaticDifficulty(4),
+			pow.NewRandomDataGenerator(32),
+			pow.NewSha256Hasher(),
+		)
+		ok, err := sha256Challenger.CheckSolution(challenge, testClient, nonce)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+}
+
+func TestChallenger_Argon2InvalidParams(t *testing.T) {
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(4),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+	)
+
+	for _, params := range []pow.Argon2Params{
+		{Memory: 0, Iterations: 1, Threads: 1},
+		{Memory: 1 << 30, Iterations: 1, Threads: 1},
+		{Memory: 64, Iterations: 0, Threads: 1},
+		{Memory: 64, Iterations: 1, Threads: 0},
+		{Memory: 64, Iterations: 1, Threads: 255},
+	} {
+		params := params
+		_, err := challenger.SolvePowChallenge(context.Background(), pow.Challenge{
+			Data:       "48656c6c6f20476f7068657221",
+			Difficulty: 4,
+			Argon2:     &params,
+		})
+		require.Error(t, err)
+	}
+}
+
+func TestArgon2Config_AdaptiveConfig(t *testing.T) {
+	adaptive := pow.AdaptiveDifficultyConfig{Enabled: true, MinDifficulty: 20, MaxDifficulty: 32, RaiseStep: 2}
+
+	cfg := pow.Argon2Config{Difficulty: 6, AdaptiveMaxDifficulty: 12}.AdaptiveConfig(adaptive)
+	require.Equal(t, 6, cfg.MinDifficulty)
+	require.Equal(t, 12, cfg.MaxDifficulty)
+	require.Equal(t, 2, cfg.RaiseStep)
+
+	cfg = pow.Argon2Config{Difficulty: 6, AdaptiveMaxDifficulty: 4}.AdaptiveConfig(adaptive)
+	require.Equal(t, 6, cfg.MaxDifficulty)
+}
+
+func BenchmarkChallenger_CheckSolution(b *testing.B) {
+	benchmarks := []struct {
+		Name string
+		Opts []pow.ChallengerOption
+	}{
+		{Name: "sha256"},
+		{Name: "argon2_8MiB", Opts: []pow.ChallengerOption{
+			pow.WithArgon2(pow.Argon2Params{Memory: 8 << 10, Iterations: 1, Threads: 1}),
+		}},
+		{Name: "argon2_64MiB", Opts: []pow.ChallengerOption{
+			pow.WithArgon2(pow.Argon2Params{Memory: 64 << 10, Iterations: 1, Threads: 1}),
+		}},
+		{Name: "argon2_64MiB_3_iterations", Opts: []pow.ChallengerOption{
+			pow.WithArgon2(pow.Argon2Params{Memory: 64 << 10, Iterations: 3, Threads: 1}),
+		}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.Name, func(b *testing.B) {
+			challenger := pow.NewChallenger(
+				pow.NewStaticDifficulty(0),
+				pow.NewRandomDataGenerator(32),
+				pow.NewSha256Hasher(),
+				bm.Opts...,
+			)
+
+			challenge, err := challenger.GenerateChallenge(testClient)
+			require.NoError(b, err)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := challenger.CheckSolution(challenge, testClient, uint64(i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "hashes/s")
+		})
+	}
+}
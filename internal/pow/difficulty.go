@@ -1,6 +1,10 @@
 package pow
 
-const powDifficulty = 7
+import "math/big"
+
+// powDifficulty is the required number of leading zero bits, it matches the
+// former 7 hex zeroes prefix.
+const powDifficulty = 28
 
 type DifficultyStorage struct{}
 
@@ -11,3 +15,44 @@ func NewDifficultyStorage() DifficultyStorage {
 func (d DifficultyStorage) GetDifficulty() int {
 	return powDifficulty
 }
+
+type StaticDifficulty struct {
+	difficulty int
+}
+
+func NewStaticDifficulty(difficulty int) StaticDifficulty {
+	return StaticDifficulty{difficulty: difficulty}
+}
+
+func (sd StaticDifficulty) GetDifficulty() int {
+	return sd.difficulty
+}
+
+type StaticTarget struct {
+	target *big.Int
+}
+
+// NewStaticTarget returns the target getter for which a solution takes work hashes on average.
+func NewStaticTarget(work float64) StaticTarget {
+	return StaticTarget{target: TargetForWork(work)}
+}
+
+func (st StaticTarget) GetTarget() *big.Int {
+	return new(big.Int).Set(st.target)
+}
+
+// AdjustedTarget is the target made harder by the bits the difficulty is raised above its base,
+// e.g. by the adaptive difficulty raised above its min under load.
+type AdjustedTarget struct {
+	target     TargetGetter
+	difficulty DifficultyGetter
+	base       int
+}
+
+func NewAdjustedTarget(target TargetGetter, difficulty DifficultyGetter, base int) AdjustedTarget {
+	return AdjustedTarget{target: target, difficulty: difficulty, base: base}
+}
+
+func (at AdjustedTarget) GetTarget() *big.Int {
+	return adjustTarget(at.target.GetTarget(), at.difficulty.GetDifficulty()-at.base)
+}
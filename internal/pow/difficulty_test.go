@@ -0,0 +1,16 @@
+package pow_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+func TestAdjustedTarget(t *testing.T) {
+	target := pow.NewStaticTarget(1 << 20)
+
+	require.Equal(t, target.GetTarget(), pow.NewAdjustedTarget(target, pow.NewStaticDifficulty(20), 20).GetTarget())
+	require.Equal(t, pow.TargetForWork(1<<22), pow.NewAdjustedTarget(target, pow.NewStaticDifficulty(22), 20).GetTarget())
+}
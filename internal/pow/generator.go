@@ -4,67 +4,249 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math"
-	"strings"
+	"math/big"
+	"sync"
+	"time"
 )
 
+// ErrNoSolution is returned when the whole nonce space is checked without a solution.
+var ErrNoSolution = errors.New("no solution error")
+
 type Challenger struct {
 	difficultyGetter    DifficultyGetter
 	randomDataGenerator RandomDataGetter
 	hasher              Hasher
+	mode                VerificationMode
+	targetGetter        TargetGetter
+	signer              *challengeSigner
+	replayCache         ReplayCache
+	difficultyAdjuster  DifficultyAdjuster
+	argon2              *Argon2Params
+	registry            *Registry
+	hashcash            *hashcashVerifier
+	trustedDifficulty   *int
+	now                 func() time.Time
+}
+
+type ChallengerOption func(c *Challenger)
+
+// WithTargetMode switches generated challenges to the target verification mode.
+func WithTargetMode(targetGetter TargetGetter) ChallengerOption {
+	return func(c *Challenger) {
+		c.mode = TargetMode
+		c.targetGetter = targetGetter
+	}
+}
+
+// WithHmacSigning makes generated challenges stateless: they are signed with
+// the secret, bound to the client address and expire after ttl.
+func WithHmacSigning(secret []byte, ttl time.Duration) ChallengerOption {
+	return func(c *Challenger) {
+		c.signer = &challengeSigner{secret: secret, ttl: ttl}
+	}
+}
+
+// WithReplayCache makes every signed challenge redeemable only once. Unsigned challenges
+// don't outlive their connection, so they aren't tracked.
+func WithReplayCache(cache ReplayCache) ChallengerOption {
+	return func(c *Challenger) {
+		c.replayCache = cache
+	}
+}
+
+// WithDifficultyAdjuster makes the challenges difficulty depend on the client.
+func WithDifficultyAdjuster(adjuster DifficultyAdjuster) ChallengerOption {
+	return func(c *Challenger) {
+		c.difficultyAdjuster = adjuster
+	}
+}
+
+// WithTrustedClientDifficulty caps the difficulty of the trusted clients, they solve
+// the challenges of at most difficulty bits.
+func WithTrustedClientDifficulty(difficulty int) ChallengerOption {
+	return func(c *Challenger) {
+		c.trustedDifficulty = &difficulty
+	}
+}
+
+// WithArgon2 switches generated challenges to the memory-hard Argon2id hash with params.
+func WithArgon2(params Argon2Params) ChallengerOption {
+	return func(c *Challenger) {
+		c.argon2 = &params
+	}
+}
+
+// WithRegistry replaces the default registry of algorithms the challenger may solve.
+func WithRegistry(registry *Registry) ChallengerOption {
+	return func(c *Challenger) {
+		c.registry = registry
+	}
+}
+
+// WithHashcash makes the challenger accept hashcash v1 stamps minted for resource
+// within window from now. It's ignored for the Argon2 challenges, stamps are SHA-1 based.
+// The stamps aren't bound to the client, so they're accepted only with the replay cache.
+func WithHashcash(resource string, window time.Duration) ChallengerOption {
+	return func(c *Challenger) {
+		c.hashcash = &hashcashVerifier{resource: resource, window: window}
+	}
+}
+
+// WithClock overrides the time source used for challenges expiration.
+func WithClock(now func() time.Time) ChallengerOption {
+	return func(c *Challenger) {
+		c.now = now
+	}
 }
 
 func NewChallenger(
 	difficultyGetter DifficultyGetter,
 	randomDataGenerator RandomDataGetter,
 	hasher Hasher,
+	opts ...ChallengerOption,
 ) *Challenger {
-	return &Challenger{
+	c := &Challenger{
 		difficultyGetter:    difficultyGetter,
 		randomDataGenerator: randomDataGenerator,
 		hasher:              hasher,
+		mode:                LeadingZeroBitsMode,
+		registry:            NewDefaultRegistry(),
+		now:                 time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
-func (c *Challenger) GenerateChallenge() (Challenge, error) {
+func (c *Challenger) GenerateChallenge(client ClientInfo) (Challenge, error) {
 	data, err := c.randomDataGenerator.GetRandomDataBytes()
 	if err != nil {
 		return Challenge{}, fmt.Errorf("generate random data bytes error: %w", err)
 	}
-	return Challenge{
-		Data:       hex.EncodeToString(data),
-		Difficulty: c.difficultyGetter.GetDifficulty(),
-	}, nil
+
+	challenge := Challenge{
+		Data:      hex.EncodeToString(data),
+		Algorithm: c.algorithm(),
+		Argon2:    c.argon2,
+	}
+	if c.mode == TargetMode {
+		challenge.Target = EncodeTarget(c.target(client))
+	} else {
+		challenge.Difficulty = c.difficulty(client)
+	}
+	if c.hashcashEnabled() {
+		challenge.HashcashResource = c.hashcash.resource
+	}
+
+	if c.signer != nil {
+		c.signer.sign(&challenge, client.Addr, c.now())
+	}
+
+	return challenge, nil
 }
 
-func (c *Challenger) CheckSolution(challenge Challenge, nonce uint64) (bool, error) {
-	data, err := hex.DecodeString(challenge.Data)
+// CheckSolution reports whether nonce solves the challenge. Signed challenges are verified
+// against the client address and expiration time, errors wrapping ErrChallengeRejected are returned
+// for the challenges which can't be accepted.
+func (c *Challenger) CheckSolution(challenge Challenge, client ClientInfo, nonce uint64) (bool, error) {
+	if c.signer != nil {
+		if err := c.signer.verify(challenge, client.Addr, c.now()); err != nil {
+			return false, err
+		}
+	} else if challenge.Signature != "" {
+		return false, ErrInvalidChallengeSignature
+	}
+
+	if (c.mode == TargetMode) != (challenge.Target != "") ||
+		challenge.GetAlgorithm() != c.algorithm() || !c.ownArgon2Params(challenge.Argon2) {
+		return false, nil
+	}
+
+	checker, err := newHashChecker(challenge)
+	if err != nil {
+		return false, fmt.Errorf("create hash checker error: %w", err)
+	}
+
+	hasher, err := c.challengeHasher(challenge)
+	if err != nil {
+		return false, fmt.Errorf("create challenge hasher error: %w", err)
+	}
+
+	buf := solutionBuffers.Get().(*solutionBuffer)
+	defer solutionBuffers.Put(buf)
+
+	buf.data, err = appendHexString(buf.data[:0], challenge.Data)
 	if err != nil {
 		return false, fmt.Errorf("decode hex from string %v error: %w", challenge.Data, err)
 	}
+	buf.data = binary.LittleEndian.AppendUint64(buf.data, nonce)
 
-	nonceBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(nonceBytes, nonce)
+	buf.hash = appendHashFunc(hasher)(buf.hash[:0], buf.data)
+	if !checker.check(buf.hash) {
+		return false, nil
+	}
 
-	data = append(data, nonceBytes...)
+	if c.replayCache != nil && challenge.Signature != "" &&
+		!c.replayCache.MarkRedeemed(challenge.Data, time.Unix(challenge.ExpiresAt, 0)) {
+		return false, ErrChallengeReplayed
+	}
 
-	return c.validateSolution(data, challenge.GetDifficultyString()), nil
+	return true, nil
+}
+
+// CheckStamp reports whether the hashcash v1 stamp is minted for the challenger resource
+// with the difficulty of the challenge issued to the client, the difficulty may change since
+// it's issued. Every stamp is accepted once when the replay cache is set.
+func (c *Challenger) CheckStamp(challenge Challenge, stamp string) (bool, error) {
+	if !c.hashcashEnabled() {
+		return false, fmt.Errorf("%w: hashcash stamps aren't accepted", ErrChallengeRejected)
+	}
+
+	now := c.now()
+
+	parsed, ok, err := c.hashcash.verify(stamp, challenge.DifficultyBits(), now)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if !c.replayCache.MarkRedeemed(stamp, parsed.Date.Add(c.hashcash.window)) {
+		return false, ErrChallengeReplayed
+	}
+
+	return true, nil
 }
 
 func (c *Challenger) SolvePowChallenge(ctx context.Context, challenge Challenge) (uint64, error) {
-	data, err := hex.DecodeString(challenge.Data)
+	return c.solveNonceRange(ctx, challenge, 0, 1)
+}
+
+// solveNonceRange checks the nonces first, first+step, first+2*step... until the solution is found.
+func (c *Challenger) solveNonceRange(ctx context.Context, challenge Challenge, first, step uint64) (uint64, error) {
+	checker, err := newHashChecker(challenge)
 	if err != nil {
-		return 0, fmt.Errorf("decode hex from string %v error: %w", challenge.Data, err)
+		return 0, fmt.Errorf("create hash checker error: %w", err)
 	}
 
-	nonceBytes := make([]byte, 8)
-	data = append(data, nonceBytes...)
-	data = data[:len(data)-8]
+	hasher, err := c.challengeHasher(challenge)
+	if err != nil {
+		return 0, fmt.Errorf("create challenge hasher error: %w", err)
+	}
+
+	data, err := appendHexString(make([]byte, 0, hex.DecodedLen(len(challenge.Data))+nonceSize), challenge.Data)
+	if err != nil {
+		return 0, fmt.Errorf("decode hex from string %v error: %w", challenge.Data, err)
+	}
+	data = data[:len(data)+nonceSize]
+	nonceBytes := data[len(data)-nonceSize:]
 
-	difficultyString := challenge.GetDifficultyString()
+	hashData := appendHashFunc(hasher)
 
-	for i := uint64(0); i < math.MaxUint64; i++ {
+	var hash []byte
+	for i := first; ; i += step {
 		select {
 		case <-ctx.Done():
 			return 0, ctx.Err()
@@ -72,15 +254,97 @@ func (c *Challenger) SolvePowChallenge(ctx context.Context, challenge Challenge)
 		}
 
 		binary.LittleEndian.PutUint64(nonceBytes, i)
-		if c.validateSolution(append(data, nonceBytes...), difficultyString) {
+		hash = hashData(hash[:0], data)
+		if checker.check(hash) {
 			return i, nil
 		}
+
+		if i > math.MaxUint64-step {
+			break
+		}
+	}
+
+	return 0, ErrNoSolution
+}
+
+// SupportsAlgorithm reports whether the challenges of the algorithm can be solved.
+func (c *Challenger) SupportsAlgorithm(name string) bool {
+	return name == "" || name == AlgorithmSha256 || c.registry.Supports(name)
+}
+
+func (c *Challenger) difficulty(client ClientInfo) int {
+	difficulty := max(c.difficultyGetter.GetDifficulty()+c.extraDifficulty(client), 0)
+	if client.Trusted && c.trustedDifficulty != nil {
+		return min(difficulty, max(*c.trustedDifficulty, 0))
+	}
+	return difficulty
+}
+
+func (c *Challenger) target(client ClientInfo) *big.Int {
+	target := adjustTarget(c.targetGetter.GetTarget(), c.extraDifficulty(client))
+	if client.Trusted && c.trustedDifficulty != nil {
+		if trusted := TargetForWork(math.Ldexp(1, *c.trustedDifficulty)); trusted.Cmp(target) > 0 {
+			return trusted
+		}
+	}
+	return target
+}
+
+func (c *Challenger) extraDifficulty(client ClientInfo) int {
+	if c.difficultyAdjuster == nil {
+		return 0
+	}
+	return c.difficultyAdjuster.ExtraDifficulty(client)
+}
+
+func (c *Challenger) hashcashEnabled() bool {
+	return c.hashcash != nil && c.replayCache != nil && c.argon2 == nil
+}
+
+func (c *Challenger) algorithm() string {
+	if c.argon2 != nil {
+		return AlgorithmArgon2id
 	}
+	return AlgorithmSha256
+}
+
+// challengeHasher returns the hasher the challenge is solved with, SHA-256 challenges
+// use the challenger hasher and the rest ones are looked up in the registry.
+func (c *Challenger) challengeHasher(challenge Challenge) (Hasher, error) {
+	if challenge.GetAlgorithm() == AlgorithmSha256 {
+		return c.hasher, nil
+	}
+	return c.registry.Hasher(challenge)
+}
+
+func (c *Challenger) ownArgon2Params(params *Argon2Params) bool {
+	if c.argon2 == nil || params == nil {
+		return c.argon2 == params
+	}
+	return *c.argon2 == *params
+}
+
+// appendHashFunc returns the function appending the hash of data to dst, it doesn't
+// allocate for the AppendHasher implementations.
+func appendHashFunc(hasher Hasher) func(dst, data []byte) []byte {
+	if appendHasher, ok := hasher.(AppendHasher); ok {
+		return appendHasher.AppendHash
+	}
+	return func(dst, data []byte) []byte {
+		return append(dst, hasher.HashData(data)...)
+	}
+}
+
+const nonceSize = 8
 
-	return 0, fmt.Errorf("no solution error")
+// solutionBuffer holds the buffers reused between the solutions checks.
+type solutionBuffer struct {
+	data []byte
+	hash []byte
 }
 
-func (c *Challenger) validateSolution(dataWithNonce []byte, difficulty string) bool {
-	hash := c.hasher.HashData(dataWithNonce)
-	return strings.HasPrefix(hex.EncodeToString(hash[:]), difficulty)
+var solutionBuffers = sync.Pool{
+	New: func() any {
+		return new(solutionBuffer)
+	},
 }
@@ -15,6 +15,8 @@ import (
 	mocks "github.com/nikvakhrameev/pow_tcp_server/mocks/internal_/pow"
 )
 
+var testClient = pow.ClientInfo{Addr: "127.0.0.1"}
+
 func TestGenerator_GenerateChallenge(t *testing.T) {
 	challenger, _, difficultyGetter, randomDataGetter := makeGeneratorWithMocks(t)
 
@@ -26,10 +28,133 @@ func TestGenerator_GenerateChallenge(t *testing.T) {
 	difficultyGetter.On("GetDifficulty").Return(testDifficulty).Once()
 	randomDataGetter.On("GetRandomDataBytes").Return([]byte(testRandomData), nil).Once()
 
-	challenge, err := challenger.GenerateChallenge()
+	challenge, err := challenger.GenerateChallenge(testClient)
 	require.NoError(t, err)
 	require.Equal(t, testDifficulty, challenge.Difficulty)
 	require.Equal(t, hex.EncodeToString([]byte(testRandomData)), challenge.Data)
+	require.Equal(t, pow.AlgorithmSha256, challenge.Algorithm)
+}
+
+func TestGenerator_GenerateChallengeTargetMode(t *testing.T) {
+	targetGetter := mocks.NewTargetGetter(t)
+	randomDataGetter := mocks.NewRandomDataGetter(t)
+	challenger := pow.NewChallenger(
+		mocks.NewDifficultyGetter(t),
+		randomDataGetter,
+		mocks.NewHasher(t),
+		pow.WithTargetMode(targetGetter),
+	)
+
+	const testRandomData = "test_data"
+
+	targetGetter.On("GetTarget").Return(pow.TargetForWork(1 << 24)).Once()
+	randomDataGetter.On("GetRandomDataBytes").Return([]byte(testRandomData), nil).Once()
+
+	challenge, err := challenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+	require.Equal(t, pow.Challenge{
+		Data:      hex.EncodeToString([]byte(testRandomData)),
+		Algorithm: pow.AlgorithmSha256,
+		Target:    "0000010000000000000000000000000000000000000000000000000000000000",
+	}, challenge)
+}
+
+func TestGenerator_GenerateChallengeDifficultyAdjuster(t *testing.T) {
+	difficultyGetter := mocks.NewDifficultyGetter(t)
+	targetGetter := mocks.NewTargetGetter(t)
+	adjuster := mocks.NewDifficultyAdjuster(t)
+	randomDataGetter := mocks.NewRandomDataGetter(t)
+
+	randomDataGetter.On("GetRandomDataBytes").Return([]byte("test_data"), nil)
+
+	challenger := pow.NewChallenger(
+		difficultyGetter,
+		randomDataGetter,
+		mocks.NewHasher(t),
+		pow.WithDifficultyAdjuster(adjuster),
+	)
+
+	difficultyGetter.On("GetDifficulty").Return(10).Twice()
+	adjuster.On("ExtraDifficulty", testClient).Return(3).Once()
+	adjuster.On("ExtraDifficulty", testClient).Return(-12).Once()
+
+	challenge, err := challenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+	require.Equal(t, 13, challenge.Difficulty)
+
+	challenge, err = challenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+	require.Equal(t, 0, challenge.Difficulty)
+
+	targetChallenger := pow.NewChallenger(
+		difficultyGetter,
+		randomDataGetter,
+		mocks.NewHasher(t),
+		pow.WithTargetMode(targetGetter),
+		pow.WithDifficultyAdjuster(adjuster),
+	)
+
+	targetGetter.On("GetTarget").Return(pow.TargetForWork(1 << 24)).Twice()
+	adjuster.On("ExtraDifficulty", testClient).Return(4).Once()
+	adjuster.On("ExtraDifficulty", testClient).Return(-30).Once()
+
+	challenge, err = targetChallenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+	require.Equal(t, "0000001000000000000000000000000000000000000000000000000000000000", challenge.Target)
+
+	challenge, err = targetChallenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+	require.Equal(t, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", challenge.Target)
+}
+
+func TestGenerator_GenerateChallengeTrustedClient(t *testing.T) {
+	difficultyGetter := mocks.NewDifficultyGetter(t)
+	targetGetter := mocks.NewTargetGetter(t)
+	randomDataGetter := mocks.NewRandomDataGetter(t)
+
+	randomDataGetter.On("GetRandomDataBytes").Return([]byte("test_data"), nil)
+
+	trustedClient := pow.ClientInfo{Addr: testClient.Addr, Trusted: true}
+
+	challenger := pow.NewChallenger(
+		difficultyGetter,
+		randomDataGetter,
+		mocks.NewHasher(t),
+		pow.WithTrustedClientDifficulty(4),
+	)
+
+	difficultyGetter.On("GetDifficulty").Return(10).Twice()
+	difficultyGetter.On("GetDifficulty").Return(2).Once()
+
+	challenge, err := challenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+	require.Equal(t, 10, challenge.Difficulty)
+
+	challenge, err = challenger.GenerateChallenge(trustedClient)
+	require.NoError(t, err)
+	require.Equal(t, 4, challenge.Difficulty)
+
+	challenge, err = challenger.GenerateChallenge(trustedClient)
+	require.NoError(t, err)
+	require.Equal(t, 2, challenge.Difficulty)
+
+	targetChallenger := pow.NewChallenger(
+		difficultyGetter,
+		randomDataGetter,
+		mocks.NewHasher(t),
+		pow.WithTargetMode(targetGetter),
+		pow.WithTrustedClientDifficulty(0),
+	)
+
+	targetGetter.On("GetTarget").Return(pow.TargetForWork(1 << 24)).Twice()
+
+	challenge, err = targetChallenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+	require.Equal(t, "0000010000000000000000000000000000000000000000000000000000000000", challenge.Target)
+
+	challenge, err = targetChallenger.GenerateChallenge(trustedClient)
+	require.NoError(t, err)
+	require.Equal(t, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", challenge.Target)
 }
 
 func TestGenerator_GenerateChallengeError(t *testing.T) {
@@ -38,7 +163,7 @@ func TestGenerator_GenerateChallengeError(t *testing.T) {
 	testErr := errors.New("test error")
 	randomDataGetter.On("GetRandomDataBytes").Return(nil, testErr).Once()
 
-	_, err := challenger.GenerateChallenge()
+	_, err := challenger.GenerateChallenge(testClient)
 	require.ErrorIs(t, err, testErr)
 }
 
@@ -47,7 +172,7 @@ func TestGenerator_CheckSolution(t *testing.T) {
 
 	const (
 		testChallengeData = "48656c6c6f20476f7068657221"
-		testDifficulty    = 3
+		testDifficulty    = 12
 
 		testCorrectNonceSolution = 10
 		testCorrectHexStringHash = "00056c6c6f20476f7068657221"
@@ -86,14 +211,14 @@ func TestGenerator_CheckSolution(t *testing.T) {
 	ok, err := challenger.CheckSolution(pow.Challenge{
 		Data:       testChallengeData,
 		Difficulty: testDifficulty,
-	}, testCorrectNonceSolution)
+	}, testClient, testCorrectNonceSolution)
 	require.NoError(t, err)
 	require.True(t, ok)
 
 	ok, err = challenger.CheckSolution(pow.Challenge{
 		Data:       testChallengeData,
 		Difficulty: testDifficulty,
-	}, testIncorrectNonceSolution)
+	}, testClient, testIncorrectNonceSolution)
 	require.NoError(t, err)
 	require.False(t, ok)
 }
@@ -103,7 +228,7 @@ func TestGenerator_SolvePowChallenge(t *testing.T) {
 
 	const (
 		testChallengeData = "48656c6c6f20476f7068657221"
-		testDifficulty    = 3
+		testDifficulty    = 12
 
 		testIncorrectNonceSolution = uint64(0)
 		testIncorrectHexStringHash = "12156c6c6f20476f7068657221"
@@ -160,6 +285,77 @@ func TestGenerator_SolvePowChallengeCtxErr(t *testing.T) {
 	require.ErrorIs(t, err, context.Canceled)
 }
 
+func TestGenerator_SolveAndCheckSha256(t *testing.T) {
+	challenger := pow.NewChallenger(
+		pow.NewDifficultyStorage(),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+	)
+
+	for _, difficulty := range []int{1, 5, 9, 13} {
+		challenge := pow.Challenge{
+			Data:       "48656c6c6f20476f7068657221",
+			Difficulty: difficulty,
+		}
+
+		nonce, err := challenger.SolvePowChallenge(context.Background(), challenge)
+		require.NoError(t, err)
+
+		ok, err := challenger.CheckSolution(challenge, testClient, nonce)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+}
+
+func TestGenerator_SolveAndCheckSha256TargetMode(t *testing.T) {
+	challenger := pow.NewChallenger(
+		pow.NewDifficultyStorage(),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+		pow.WithTargetMode(pow.NewStaticTarget(1500)),
+	)
+
+	challenge, err := challenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+
+	nonce, err := challenger.SolvePowChallenge(context.Background(), challenge)
+	require.NoError(t, err)
+
+	ok, err := challenger.CheckSolution(challenge, testClient, nonce)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	challenge.Target = ""
+	challenge.Difficulty = 0
+	ok, err = challenger.CheckSolution(challenge, testClient, nonce)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func BenchmarkChallenger_SolvePowChallenge(b *testing.B) {
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(16),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+	)
+
+	b.ReportAllocs()
+
+	var hashes uint64
+	for i := 0; i < b.N; i++ {
+		challenge, err := challenger.GenerateChallenge(testClient)
+		require.NoError(b, err)
+
+		nonce, err := challenger.SolvePowChallenge(context.Background(), challenge)
+		if err != nil {
+			b.Fatal(err)
+		}
+		hashes += nonce + 1
+	}
+
+	b.ReportMetric(float64(hashes)/b.Elapsed().Seconds(), "hashes/s")
+}
+
 func makeGeneratorWithMocks(t *testing.T) (
 	*pow.Challenger,
 	*mocks.Hasher,
@@ -0,0 +1,150 @@
+package pow
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	hashcashVersion = 1
+	// hashcashDateLayout is the layout of minted stamps dates, the parsed ones may
+	// omit the time or seconds.
+	hashcashDateLayout = "060102150405"
+	hashcashRandBytes  = 12
+)
+
+var hashcashDateLayouts = map[int]string{
+	6:  "060102",
+	10: "0601021504",
+	12: hashcashDateLayout,
+}
+
+var (
+	// ErrInvalidHashcashStamp is returned for the stamps which can't be parsed.
+	ErrInvalidHashcashStamp = fmt.Errorf("%w: invalid hashcash stamp", ErrChallengeRejected)
+	// ErrHashcashResource is returned for the stamps minted for another resource.
+	ErrHashcashResource = fmt.Errorf("%w: hashcash stamp resource mismatch", ErrChallengeRejected)
+)
+
+// HashcashStamp is the hashcash v1 stamp: 1:bits:date:resource:ext:rand:counter.
+// It's valid when SHA-1 of its string form has bits leading zero bits.
+type HashcashStamp struct {
+	Bits     int
+	Date     time.Time
+	Resource string
+	Ext      string
+	Rand     string
+	Counter  string
+}
+
+func ParseHashcashStamp(s string) (HashcashStamp, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 7 {
+		return HashcashStamp{}, fmt.Errorf("%w: %v fields instead of 7", ErrInvalidHashcashStamp, len(fields))
+	}
+
+	if fields[0] != strconv.Itoa(hashcashVersion) {
+		return HashcashStamp{}, fmt.Errorf("%w: unsupported version %q", ErrInvalidHashcashStamp, fields[0])
+	}
+
+	bits, err := strconv.Atoi(fields[1])
+	if err != nil || bits < 0 || bits > sha1.Size*8 {
+		return HashcashStamp{}, fmt.Errorf("%w: invalid bits %q", ErrInvalidHashcashStamp, fields[1])
+	}
+
+	layout, ok := hashcashDateLayouts[len(fields[2])]
+	if !ok {
+		return HashcashStamp{}, fmt.Errorf("%w: invalid date %q", ErrInvalidHashcashStamp, fields[2])
+	}
+	date, err := time.Parse(layout, fields[2])
+	if err != nil {
+		return HashcashStamp{}, fmt.Errorf("%w: invalid date %q", ErrInvalidHashcashStamp, fields[2])
+	}
+
+	return HashcashStamp{
+		Bits:     bits,
+		Date:     date,
+		Resource: fields[3],
+		Ext:      fields[4],
+		Rand:     fields[5],
+		Counter:  fields[6],
+	}, nil
+}
+
+func (hs HashcashStamp) String() string {
+	return strings.Join([]string{
+		strconv.Itoa(hashcashVersion),
+		strconv.Itoa(hs.Bits),
+		hs.Date.UTC().Format(hashcashDateLayout),
+		hs.Resource,
+		hs.Ext,
+		hs.Rand,
+		hs.Counter,
+	}, ":")
+}
+
+// MintHashcashStamp finds the counter making the stamp for resource valid.
+func MintHashcashStamp(ctx context.Context, resource string, bits int, now time.Time) (HashcashStamp, error) {
+	randBytes := make([]byte, hashcashRandBytes)
+	if _, err := rand.Read(randBytes); err != nil {
+		return HashcashStamp{}, fmt.Errorf("read random bytes error: %w", err)
+	}
+
+	stamp := HashcashStamp{
+		Bits:     bits,
+		Date:     now.UTC().Truncate(time.Second),
+		Resource: resource,
+		Rand:     base64.StdEncoding.EncodeToString(randBytes),
+	}
+
+	prefix := []byte(stamp.String())
+	buf := make([]byte, 0, len(prefix)+16)
+
+	for counter := uint64(0); counter < math.MaxUint64; counter++ {
+		select {
+		case <-ctx.Done():
+			return HashcashStamp{}, ctx.Err()
+		default:
+		}
+
+		buf = strconv.AppendUint(append(buf[:0], prefix...), counter, 36)
+		hash := sha1.Sum(buf)
+		if LeadingZeroBits(hash[:]) >= bits {
+			stamp.Counter = strconv.FormatUint(counter, 36)
+			return stamp, nil
+		}
+	}
+
+	return HashcashStamp{}, ErrNoSolution
+}
+
+// hashcashVerifier checks the stamps minted for the server resource within the date window.
+type hashcashVerifier struct {
+	resource string
+	window   time.Duration
+}
+
+func (hv hashcashVerifier) verify(stamp string, bits int, now time.Time) (HashcashStamp, bool, error) {
+	parsed, err := ParseHashcashStamp(stamp)
+	if err != nil {
+		return HashcashStamp{}, false, err
+	}
+
+	if parsed.Resource != hv.resource {
+		return HashcashStamp{}, false, ErrHashcashResource
+	}
+
+	if age := now.Sub(parsed.Date); age > hv.window || age < -hv.window {
+		return HashcashStamp{}, false, ErrChallengeExpired
+	}
+
+	hash := sha1.Sum([]byte(stamp))
+	return parsed, parsed.Bits >= bits && LeadingZeroBits(hash[:]) >= parsed.Bits, nil
+}
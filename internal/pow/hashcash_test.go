@@ -0,0 +1,130 @@
+package pow_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+const testHashcashResource = "pow.example.com"
+
+func TestParseHashcashStamp(t *testing.T) {
+	const stamp = "1:20:060408:adam@cypherspace.org::1QTjaYd7niiQA/sc:ePa"
+
+	parsed, err := pow.ParseHashcashStamp(stamp)
+	require.NoError(t, err)
+	require.Equal(t, pow.HashcashStamp{
+		Bits:     20,
+		Date:     time.Date(2006, 4, 8, 0, 0, 0, 0, time.UTC),
+		Resource: "adam@cypherspace.org",
+		Rand:     "1QTjaYd7niiQA/sc",
+		Counter:  "ePa",
+	}, parsed)
+
+	hash := sha1.Sum([]byte(stamp))
+	require.GreaterOrEqual(t, pow.LeadingZeroBits(hash[:]), parsed.Bits)
+
+	parsed.Date = time.Date(2024, 3, 1, 12, 30, 15, 0, time.UTC)
+	require.Equal(t, "1:20:240301123015:adam@cypherspace.org::1QTjaYd7niiQA/sc:ePa", parsed.String())
+
+	reparsed, err := pow.ParseHashcashStamp(parsed.String())
+	require.NoError(t, err)
+	require.Equal(t, parsed, reparsed)
+}
+
+func TestParseHashcashStampError(t *testing.T) {
+	for _, stamp := range []string{
+		"",
+		"1:20:060408:adam@cypherspace.org::1QTjaYd7niiQA/sc",
+		"0:20:060408:adam@cypherspace.org::1QTjaYd7niiQA/sc:ePa",
+		"1:-1:060408:adam@cypherspace.org::1QTjaYd7niiQA/sc:ePa",
+		"1:161:060408:adam@cypherspace.org::1QTjaYd7niiQA/sc:ePa",
+		"1:20:0604:adam@cypherspace.org::1QTjaYd7niiQA/sc:ePa",
+		"1:20:061340:adam@cypherspace.org::1QTjaYd7niiQA/sc:ePa",
+	} {
+		_, err := pow.ParseHashcashStamp(stamp)
+		require.ErrorIs(t, err, pow.ErrInvalidHashcashStamp, stamp)
+		require.ErrorIs(t, err, pow.ErrChallengeRejected, stamp)
+	}
+}
+
+func TestChallenger_CheckStamp(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(12),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+		pow.WithHashcash(testHashcashResource, 10*time.Minute),
+		pow.WithReplayCache(pow.NewShardedReplayCache(100)),
+		pow.WithClock(func() time.Time { return now }),
+	)
+
+	challenge, err := challenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+	require.Equal(t, testHashcashResource, challenge.HashcashResource)
+
+	stamp, err := pow.MintHashcashStamp(context.Background(), challenge.HashcashResource, challenge.Difficulty, now)
+	require.NoError(t, err)
+
+	ok, err := challenger.CheckStamp(challenge, stamp.String())
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, err = challenger.CheckStamp(challenge, stamp.String())
+	require.ErrorIs(t, err, pow.ErrChallengeReplayed)
+
+	// The stamp is checked against the difficulty of the issued challenge.
+	easyChallenge := challenge
+	easyChallenge.Difficulty = 4
+	easyStamp, err := pow.MintHashcashStamp(context.Background(), testHashcashResource, 4, now)
+	require.NoError(t, err)
+	ok, err = challenger.CheckStamp(easyChallenge, easyStamp.String())
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	weakStamp, err := pow.MintHashcashStamp(context.Background(), testHashcashResource, 4, now)
+	require.NoError(t, err)
+	ok, err = challenger.CheckStamp(challenge, weakStamp.String())
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	foreignStamp, err := pow.MintHashcashStamp(context.Background(), "another.example.com", 12, now)
+	require.NoError(t, err)
+	_, err = challenger.CheckStamp(challenge, foreignStamp.String())
+	require.ErrorIs(t, err, pow.ErrHashcashResource)
+
+	staleStamp, err := pow.MintHashcashStamp(context.Background(), testHashcashResource, 12, now.Add(-time.Hour))
+	require.NoError(t, err)
+	_, err = challenger.CheckStamp(challenge, staleStamp.String())
+	require.ErrorIs(t, err, pow.ErrChallengeExpired)
+}
+
+func TestChallenger_CheckStampDisabled(t *testing.T) {
+	for name, opts := range map[string][]pow.ChallengerOption{
+		"disabled": nil,
+		// The stamps aren't bound to the client, they'd be replayed without the cache.
+		"without_replay_cache": {pow.WithHashcash(testHashcashResource, 10*time.Minute)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			challenger := pow.NewChallenger(
+				pow.NewStaticDifficulty(12),
+				pow.NewRandomDataGenerator(32),
+				pow.NewSha256Hasher(),
+				opts...,
+			)
+
+			challenge, err := challenger.GenerateChallenge(testClient)
+			require.NoError(t, err)
+			require.Empty(t, challenge.HashcashResource)
+
+			_, err = challenger.CheckStamp(challenge, "1:20:060408:adam@cypherspace.org::1QTjaYd7niiQA/sc:ePa")
+			require.ErrorIs(t, err, pow.ErrChallengeRejected)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package pow
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// ParallelSolver solves challenges on several goroutines, every worker checks
+// its own share of the nonce space interleaved with the others.
+type ParallelSolver struct {
+	challenger *Challenger
+	workers    int
+}
+
+// NewParallelSolver returns the solver with workers goroutines, GOMAXPROCS is used
+// when workers isn't positive.
+func NewParallelSolver(challenger *Challenger, workers int) *ParallelSolver {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &ParallelSolver{
+		challenger: challenger,
+		workers:    workers,
+	}
+}
+
+func (ps *ParallelSolver) Workers() int {
+	return ps.workers
+}
+
+func (ps *ParallelSolver) SupportsAlgorithm(name string) bool {
+	return ps.challenger.SupportsAlgorithm(name)
+}
+
+// SolvePowChallenge returns the first solution found by any worker, the rest
+// workers are stopped as soon as it's found or ctx is done.
+func (ps *ParallelSolver) SolvePowChallenge(ctx context.Context, challenge Challenge) (uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		nonce uint64
+		err   error
+	}
+
+	results := make(chan result, ps.workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < ps.workers; i++ {
+		wg.Add(1)
+		go func(first uint64) {
+			defer wg.Done()
+			nonce, err := ps.challenger.solveNonceRange(ctx, challenge, first, uint64(ps.workers))
+			results <- result{nonce: nonce, err: err}
+		}(uint64(i))
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// every worker fails with the same error unless the solution is found
+	var err error
+	for res := range results {
+		if res.err == nil {
+			return res.nonce, nil
+		}
+		err = res.err
+	}
+
+	return 0, err
+}
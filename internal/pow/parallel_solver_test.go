@@ -0,0 +1,87 @@
+package pow_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+func TestParallelSolver_SolvePowChallenge(t *testing.T) {
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(14),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+	)
+
+	for _, workers := range []int{1, 3, 8} {
+		solver := pow.NewParallelSolver(challenger, workers)
+		require.Equal(t, workers, solver.Workers())
+
+		challenge, err := challenger.GenerateChallenge(testClient)
+		require.NoError(t, err)
+
+		nonce, err := solver.SolvePowChallenge(context.Background(), challenge)
+		require.NoError(t, err)
+
+		ok, err := challenger.CheckSolution(challenge, testClient, nonce)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+}
+
+func TestParallelSolver_DefaultWorkers(t *testing.T) {
+	solver := pow.NewParallelSolver(pow.NewChallenger(nil, nil, pow.NewSha256Hasher()), 0)
+	require.Equal(t, runtime.GOMAXPROCS(0), solver.Workers())
+	require.True(t, solver.SupportsAlgorithm(pow.AlgorithmArgon2id))
+	require.False(t, solver.SupportsAlgorithm("scrypt"))
+}
+
+func TestParallelSolver_CtxErr(t *testing.T) {
+	solver := pow.NewParallelSolver(pow.NewChallenger(nil, nil, pow.NewSha256Hasher()), 4)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := solver.SolvePowChallenge(ctx, pow.Challenge{
+		Data:       "48656c6c6f20476f7068657221",
+		Difficulty: 256,
+	})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestParallelSolver_InvalidChallenge(t *testing.T) {
+	solver := pow.NewParallelSolver(pow.NewChallenger(nil, nil, pow.NewSha256Hasher()), 4)
+
+	_, err := solver.SolvePowChallenge(context.Background(), pow.Challenge{
+		Data:       "invalid hex",
+		Difficulty: 1,
+	})
+	require.Error(t, err)
+}
+
+func BenchmarkParallelSolver_SolvePowChallenge(b *testing.B) {
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(16),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+	)
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		solver := pow.NewParallelSolver(challenger, workers)
+		b.Run(fmt.Sprintf("workers_%v", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				challenge, err := challenger.GenerateChallenge(testClient)
+				require.NoError(b, err)
+
+				if _, err := solver.SolvePowChallenge(context.Background(), challenge); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package pow
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	AlgorithmSha256   = "sha256"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// ErrUnsupportedAlgorithm is returned for challenges of algorithms missing in the registry.
+var ErrUnsupportedAlgorithm = errors.New("unsupported pow algorithm")
+
+// Algorithm creates hashers for the challenges of a PoW algorithm from their params.
+type Algorithm interface {
+	Hasher(challenge Challenge) (Hasher, error)
+}
+
+type AlgorithmFunc func(challenge Challenge) (Hasher, error)
+
+func (f AlgorithmFunc) Hasher(challenge Challenge) (Hasher, error) {
+	return f(challenge)
+}
+
+// Registry maps the algorithm identifiers carried in challenges to their implementations.
+type Registry struct {
+	mu         sync.RWMutex
+	algorithms map[string]Algorithm
+}
+
+func NewRegistry() *Registry {
+	return &Registry{algorithms: make(map[string]Algorithm)}
+}
+
+// NewDefaultRegistry returns the registry of all algorithms implemented in the package.
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(AlgorithmSha256, AlgorithmFunc(func(Challenge) (Hasher, error) {
+		return NewSha256Hasher(), nil
+	}))
+	r.Register(AlgorithmArgon2id, AlgorithmFunc(func(challenge Challenge) (Hasher, error) {
+		if challenge.Argon2 == nil {
+			return nil, fmt.Errorf("argon2 params are missing")
+		}
+		if err := challenge.Argon2.validate(); err != nil {
+			return nil, err
+		}
+		return NewArgon2Hasher(*challenge.Argon2), nil
+	}))
+	return r
+}
+
+func (r *Registry) Register(name string, algorithm Algorithm) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.algorithms[name] = algorithm
+}
+
+func (r *Registry) Supports(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.algorithms[name]
+	return ok
+}
+
+// Names returns the sorted identifiers of the registered algorithms.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.algorithms))
+	for name := range r.algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Registry) Hasher(challenge Challenge) (Hasher, error) {
+	name := challenge.GetAlgorithm()
+
+	r.mu.RLock()
+	algorithm, ok := r.algorithms[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, name)
+	}
+
+	hasher, err := algorithm.Hasher(challenge)
+	if err != nil {
+		return nil, fmt.Errorf("create %v hasher error: %w", name, err)
+	}
+	return hasher, nil
+}
@@ -0,0 +1,82 @@
+package pow_test
+
+import (
+	"context"
+	"crypto/sha512"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := pow.NewDefaultRegistry()
+
+	require.Equal(t, []string{pow.AlgorithmArgon2id, pow.AlgorithmSha256}, registry.Names())
+	require.True(t, registry.Supports(pow.AlgorithmSha256))
+	require.False(t, registry.Supports("scrypt"))
+
+	hasher, err := registry.Hasher(pow.Challenge{})
+	require.NoError(t, err)
+	require.IsType(t, pow.Sha256Hasher{}, hasher)
+
+	hasher, err = registry.Hasher(pow.Challenge{Algorithm: pow.AlgorithmArgon2id, Argon2: &testArgon2Params})
+	require.NoError(t, err)
+	require.IsType(t, pow.Argon2Hasher{}, hasher)
+
+	_, err = registry.Hasher(pow.Challenge{Algorithm: pow.AlgorithmArgon2id})
+	require.Error(t, err)
+
+	_, err = registry.Hasher(pow.Challenge{Algorithm: "scrypt"})
+	require.ErrorIs(t, err, pow.ErrUnsupportedAlgorithm)
+}
+
+func TestChallenger_CustomAlgorithm(t *testing.T) {
+	const algorithm = "sha512"
+
+	registry := pow.NewDefaultRegistry()
+	registry.Register(algorithm, pow.AlgorithmFunc(func(pow.Challenge) (pow.Hasher, error) {
+		return sha512Hasher{}, nil
+	}))
+
+	challenge := pow.Challenge{
+		Data:       "48656c6c6f20476f7068657221",
+		Algorithm:  algorithm,
+		Difficulty: 8,
+	}
+
+	solver := pow.NewChallenger(
+		pow.NewStaticDifficulty(8),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+		pow.WithRegistry(registry),
+	)
+	require.True(t, solver.SupportsAlgorithm(algorithm))
+
+	nonce, err := solver.SolvePowChallenge(context.Background(), challenge)
+	require.NoError(t, err)
+
+	defaultSolver := pow.NewChallenger(
+		pow.NewStaticDifficulty(8),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+	)
+	require.False(t, defaultSolver.SupportsAlgorithm(algorithm))
+	require.True(t, defaultSolver.SupportsAlgorithm(""))
+
+	_, err = defaultSolver.SolvePowChallenge(context.Background(), challenge)
+	require.ErrorIs(t, err, pow.ErrUnsupportedAlgorithm)
+
+	// the challenger only accepts the challenges of its own algorithm
+	ok, err := solver.CheckSolution(challenge, testClient, nonce)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+type sha512Hasher struct{}
+
+func (sha512Hasher) HashData(data []byte) []byte {
+	hash := sha512.Sum512(data)
+	return hash[:]
+}
@@ -0,0 +1,116 @@
+package pow
+
+import (
+	"container/heap"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+const replayCacheShards = 32
+
+// ShardedReplayCache is the in-memory ReplayCache. Keys are spread over independently
+// locked shards, every shard holds at most capacity/shards keys. The live keys are never
+// evicted, so the new keys are rejected while the shard is full of them.
+type ShardedReplayCache struct {
+	seed   maphash.Seed
+	shards []*replayCacheShard
+	now    func() time.Time
+}
+
+func NewShardedReplayCache(capacity int) *ShardedReplayCache {
+	shardCapacity := capacity / replayCacheShards
+	if shardCapacity < 1 {
+		shardCapacity = 1
+	}
+
+	shards := make([]*replayCacheShard, replayCacheShards)
+	for i := range shards {
+		shards[i] = &replayCacheShard{
+			capacity: shardCapacity,
+			keys:     make(map[string]*replayCacheEntry),
+		}
+	}
+
+	return &ShardedReplayCache{
+		seed:   maphash.MakeSeed(),
+		shards: shards,
+		now:    time.Now,
+	}
+}
+
+func (rc *ShardedReplayCache) MarkRedeemed(key string, expiresAt time.Time) bool {
+	shard := rc.shards[maphash.String(rc.seed, key)%uint64(len(rc.shards))]
+	return shard.markRedeemed(key, expiresAt, rc.now())
+}
+
+// Len returns the number of tracked keys.
+func (rc *ShardedReplayCache) Len() int {
+	var n int
+	for _, shard := range rc.shards {
+		shard.mu.Lock()
+		n += len(shard.keys)
+		shard.mu.Unlock()
+	}
+	return n
+}
+
+type replayCacheShard struct {
+	mu       sync.Mutex
+	capacity int
+	keys     map[string]*replayCacheEntry
+	expiries replayCacheHeap
+}
+
+func (s *replayCacheShard) markRedeemed(key string, expiresAt, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.expiries) > 0 && !s.expiries[0].expiresAt.After(now) {
+		s.evictFirst()
+	}
+
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+
+	// Evicting the live key would let it be redeemed again.
+	if len(s.expiries) >= s.capacity {
+		return false
+	}
+
+	entry := &replayCacheEntry{key: key, expiresAt: expiresAt}
+	s.keys[key] = entry
+	heap.Push(&s.expiries, entry)
+
+	return true
+}
+
+func (s *replayCacheShard) evictFirst() {
+	entry := heap.Pop(&s.expiries).(*replayCacheEntry)
+	delete(s.keys, entry.key)
+}
+
+type replayCacheEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// replayCacheHeap is the min-heap of entries ordered by expiration time.
+type replayCacheHeap []*replayCacheEntry
+
+func (h replayCacheHeap) Len() int           { return len(h) }
+func (h replayCacheHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h replayCacheHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *replayCacheHeap) Push(x any) {
+	*h = append(*h, x.(*replayCacheEntry))
+}
+
+func (h *replayCacheHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
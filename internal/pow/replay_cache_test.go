@@ -0,0 +1,149 @@
+package pow_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+func TestShardedReplayCache_MarkRedeemed(t *testing.T) {
+	cache := pow.NewShardedReplayCache(1000)
+
+	expiresAt := time.Now().Add(time.Hour)
+	require.True(t, cache.MarkRedeemed("key", expiresAt))
+	require.False(t, cache.MarkRedeemed("key", expiresAt))
+	require.True(t, cache.MarkRedeemed("other_key", expiresAt))
+
+	expired := time.Now().Add(-time.Second)
+	require.True(t, cache.MarkRedeemed("expired_key", expired))
+	require.True(t, cache.MarkRedeemed("expired_key", expired))
+}
+
+func TestShardedReplayCache_Bounded(t *testing.T) {
+	const capacity = 64
+	cache := pow.NewShardedReplayCache(capacity)
+
+	expiresAt := time.Now().Add(200 * time.Millisecond)
+	var redeemed []string
+	for i := 0; i < 10*capacity; i++ {
+		key := fmt.Sprintf("key_%v", i)
+		if cache.MarkRedeemed(key, expiresAt) {
+			redeemed = append(redeemed, key)
+		}
+	}
+
+	require.NotEmpty(t, redeemed)
+	require.Less(t, len(redeemed), 10*capacity, "the full cache must reject new keys")
+	require.LessOrEqual(t, cache.Len(), capacity)
+
+	// The flood of new keys doesn't push out the live ones.
+	for _, key := range redeemed {
+		require.False(t, cache.MarkRedeemed(key, expiresAt), key)
+	}
+
+	// The expired keys free the room.
+	time.Sleep(time.Until(expiresAt))
+	require.True(t, cache.MarkRedeemed(redeemed[0], time.Now().Add(time.Hour)))
+}
+
+func TestShardedReplayCache_ConcurrentDoubleSpend(t *testing.T) {
+	cache := pow.NewShardedReplayCache(1000)
+
+	const (
+		keys     = 16
+		attempts = 32
+	)
+
+	var redeemed [keys]atomic.Int32
+
+	expiresAt := time.Now().Add(time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < keys*attempts; i++ {
+		wg.Add(1)
+		go func(key int) {
+			defer wg.Done()
+			if cache.MarkRedeemed(fmt.Sprintf("key_%v", key), expiresAt) {
+				redeemed[key].Add(1)
+			}
+		}(i % keys)
+	}
+	wg.Wait()
+
+	for i := range redeemed {
+		require.Equal(t, int32(1), redeemed[i].Load())
+	}
+}
+
+func TestChallenger_ReplayedSolution(t *testing.T) {
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(4),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+		pow.WithHmacSigning([]byte("secret"), time.Minute),
+		pow.WithReplayCache(pow.NewShardedReplayCache(1000)),
+	)
+
+	challenge, err := challenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+
+	nonce, err := challenger.SolvePowChallenge(context.Background(), challenge)
+	require.NoError(t, err)
+
+	const attempts = 32
+
+	var (
+		wg       sync.WaitGroup
+		accepted atomic.Int32
+		replayed atomic.Int32
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := challenger.CheckSolution(challenge, testClient, nonce)
+			switch {
+			case ok && err == nil:
+				accepted.Add(1)
+			case errors.Is(err, pow.ErrChallengeReplayed):
+				replayed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), accepted.Load())
+	require.Equal(t, int32(attempts-1), replayed.Load())
+}
+
+func TestChallenger_InvalidSolutionDoesntRedeem(t *testing.T) {
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(16),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+		pow.WithHmacSigning([]byte("secret"), time.Minute),
+		pow.WithReplayCache(pow.NewShardedReplayCache(1000)),
+	)
+
+	challenge, err := challenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+
+	nonce, err := challenger.SolvePowChallenge(context.Background(), challenge)
+	require.NoError(t, err)
+
+	ok, err := challenger.CheckSolution(challenge, testClient, nonce+1)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = challenger.CheckSolution(challenge, testClient, nonce)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
@@ -0,0 +1,230 @@
+package pow
+
+import (
+	"container/heap"
+	"hash/maphash"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+type ReputationConfig struct {
+	Enabled bool `envconfig:"ENABLED"`
+	// HalfLife is the time for which the client score halves.
+	HalfLife time.Duration `envconfig:"HALF_LIFE" default:"1m"`
+	// ConnectionPenalty, FailurePenalty and TimeoutPenalty are added to the client score,
+	// SuccessReward is subtracted from it. The score rounded is the extra difficulty in bits.
+	ConnectionPenalty float64 `envconfig:"CONNECTION_PENALTY" default:"0.25"`
+	FailurePenalty    float64 `envconfig:"FAILURE_PENALTY" default:"1"`
+	TimeoutPenalty    float64 `envconfig:"TIMEOUT_PENALTY" default:"1"`
+	SuccessReward     float64 `envconfig:"SUCCESS_REWARD" default:"0.2"`
+	// SubnetWeight is the weight of the whole client subnet score (/24 for IPv4, /64 for IPv6).
+	SubnetWeight float64 `envconfig:"SUBNET_WEIGHT" default:"0.25"`
+	// MaxPenalty and MaxBonus bound the extra difficulty in both directions.
+	MaxPenalty int `envconfig:"MAX_PENALTY" default:"8"`
+	MaxBonus   int `envconfig:"MAX_BONUS" default:"2"`
+	// MaxEntries is the max number of tracked addresses and subnets, the lowest scores are
+	// evicted when it's reached.
+	MaxEntries int `envconfig:"MAX_ENTRIES" default:"100000"`
+}
+
+// reputationShards is the max number of independently locked shards of the scores, the
+// trackers of less than minReputationShardEntries entries per shard use fewer shards.
+const (
+	reputationShards          = 32
+	minReputationShardEntries = 1024
+)
+
+// ReputationTracker scores clients by their connections history and adjusts
+// their challenges difficulty accordingly. The scores are spread over independently
+// locked shards, the full shard evicts its lowest score, so the flood of new clients
+// doesn't flush the penalties.
+type ReputationTracker struct {
+	cfg ReputationConfig
+	now func() time.Time
+	// epoch is the reference time of the scores order.
+	epoch time.Time
+
+	seed   maphash.Seed
+	shards []*reputationShard
+}
+
+func NewReputationTracker(cfg ReputationConfig, now func() time.Time) *ReputationTracker {
+	shardsCount := min(reputationShards, max(1, cfg.MaxEntries/minReputationShardEntries))
+	shardCapacity := max(1, cfg.MaxEntries/shardsCount)
+
+	shards := make([]*reputationShard, shardsCount)
+	for i := range shards {
+		shards[i] = &reputationShard{
+			capacity: shardCapacity,
+			scores:   make(map[string]*reputationScore),
+		}
+	}
+
+	return &ReputationTracker{
+		cfg:    cfg,
+		now:    now,
+		epoch:  now(),
+		seed:   maphash.MakeSeed(),
+		shards: shards,
+	}
+}
+
+func (rt *ReputationTracker) ConnectionOpened(clientAddr string) {
+	rt.add(clientAddr, rt.cfg.ConnectionPenalty)
+}
+
+func (rt *ReputationTracker) VerificationSucceeded(clientAddr string) {
+	rt.add(clientAddr, -rt.cfg.SuccessReward)
+}
+
+func (rt *ReputationTracker) VerificationFailed(clientAddr string) {
+	rt.add(clientAddr, rt.cfg.FailurePenalty)
+}
+
+func (rt *ReputationTracker) ConnectionTimedOut(clientAddr string) {
+	rt.add(clientAddr, rt.cfg.TimeoutPenalty)
+}
+
+func (rt *ReputationTracker) ExtraDifficulty(client ClientInfo) int {
+	now := rt.now()
+	score := rt.score(client.Addr, now)
+	if subnet := subnetKey(client.Addr); subnet != "" {
+		score += rt.cfg.SubnetWeight * rt.score(subnet, now)
+	}
+
+	extra := int(math.Round(score))
+	return min(max(extra, -rt.cfg.MaxBonus), rt.cfg.MaxPenalty)
+}
+
+func (rt *ReputationTracker) add(clientAddr string, delta float64) {
+	now := rt.now()
+	rt.addScore(clientAddr, delta, now)
+	if subnet := subnetKey(clientAddr); subnet != "" {
+		rt.addScore(subnet, delta, now)
+	}
+}
+
+func (rt *ReputationTracker) shard(key string) *reputationShard {
+	return rt.shards[maphash.String(rt.seed, key)%uint64(len(rt.shards))]
+}
+
+func (rt *ReputationTracker) addScore(key string, delta float64, now time.Time) {
+	shard := rt.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	s, ok := shard.scores[key]
+	if !ok {
+		if len(shard.order) >= shard.capacity {
+			evicted := heap.Pop(&shard.order).(*reputationScore)
+			delete(shard.scores, evicted.key)
+		}
+		s = &reputationScore{key: key, updatedAt: now}
+		shard.scores[key] = s
+		heap.Push(&shard.order, s)
+	}
+
+	s.value = s.decayed(now, rt.cfg.HalfLife) + delta
+	s.updatedAt = now
+	s.rank = s.rankAt(rt.epoch, rt.cfg.HalfLife)
+	heap.Fix(&shard.order, s.index)
+}
+
+func (rt *ReputationTracker) score(key string, now time.Time) float64 {
+	shard := rt.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	s, ok := shard.scores[key]
+	if !ok {
+		return 0
+	}
+	return s.decayed(now, rt.cfg.HalfLife)
+}
+
+type reputationShard struct {
+	mu       sync.Mutex
+	capacity int
+	scores   map[string]*reputationScore
+	order    reputationHeap
+}
+
+type reputationScore struct {
+	key       string
+	value     float64
+	updatedAt time.Time
+	// rank orders the scores as their decayed values, it doesn't change with time.
+	rank  reputationRank
+	index int
+}
+
+func (rs *reputationScore) decayed(now time.Time, halfLife time.Duration) float64 {
+	return rs.value * math.Exp2(-float64(now.Sub(rs.updatedAt))/float64(halfLife))
+}
+
+// rankAt returns the rank of the score. All the scores decay at the same rate, so the decayed
+// value sign and log2 magnitude scaled back to the epoch order them at any time.
+func (rs *reputationScore) rankAt(epoch time.Time, halfLife time.Duration) reputationRank {
+	if rs.value == 0 {
+		return reputationRank{}
+	}
+	magnitude := math.Log2(math.Abs(rs.value)) + float64(rs.updatedAt.Sub(epoch))/float64(halfLife)
+	if rs.value < 0 {
+		return reputationRank{sign: -1, magnitude: magnitude}
+	}
+	return reputationRank{sign: 1, magnitude: magnitude}
+}
+
+type reputationRank struct {
+	sign      int
+	magnitude float64
+}
+
+func (r reputationRank) less(other reputationRank) bool {
+	if r.sign != other.sign {
+		return r.sign < other.sign
+	}
+	if r.sign < 0 {
+		return r.magnitude > other.magnitude
+	}
+	return r.magnitude < other.magnitude
+}
+
+// reputationHeap is the min-heap of scores ordered by rank, the lowest score is evicted first.
+type reputationHeap []*reputationScore
+
+func (h reputationHeap) Len() int           { return len(h) }
+func (h reputationHeap) Less(i, j int) bool { return h[i].rank.less(h[j].rank) }
+
+func (h reputationHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *reputationHeap) Push(x any) {
+	s := x.(*reputationScore)
+	s.index = len(*h)
+	*h = append(*h, s)
+}
+
+func (h *reputationHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return s
+}
+
+// subnetKey returns the client subnet: /24 for IPv4 and /64 for IPv6 addresses.
+func subnetKey(clientAddr string) string {
+	ip := net.ParseIP(clientAddr)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
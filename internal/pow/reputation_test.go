@@ -0,0 +1,118 @@
+package pow_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+func TestReputationTracker(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rt := pow.NewReputationTracker(pow.ReputationConfig{
+		HalfLife:          time.Minute,
+		ConnectionPenalty: 0.25,
+		FailurePenalty:    1,
+		TimeoutPenalty:    1,
+		SuccessReward:     0.5,
+		SubnetWeight:      0.5,
+		MaxPenalty:        6,
+		MaxBonus:          1,
+		MaxEntries:        1000,
+	}, func() time.Time { return now })
+
+	var (
+		attacker  = pow.ClientInfo{Addr: "10.0.0.1"}
+		neighbour = pow.ClientInfo{Addr: "10.0.0.2"}
+		stranger  = pow.ClientInfo{Addr: "10.0.1.1"}
+		polite    = pow.ClientInfo{Addr: "2001:db8::1"}
+	)
+
+	require.Equal(t, 0, rt.ExtraDifficulty(attacker))
+
+	t.Run("failures_escalate_difficulty", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			rt.ConnectionOpened(attacker.Addr)
+			rt.VerificationFailed(attacker.Addr)
+		}
+		rt.ConnectionOpened(attacker.Addr)
+		rt.ConnectionTimedOut(attacker.Addr)
+
+		// 3.75 for the address and a half of it for the subnet
+		require.Equal(t, 6, rt.ExtraDifficulty(attacker))
+		require.Equal(t, 2, rt.ExtraDifficulty(neighbour))
+		require.Equal(t, 0, rt.ExtraDifficulty(stranger))
+	})
+
+	t.Run("bounded_by_max_penalty", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			rt.VerificationFailed(attacker.Addr)
+		}
+		require.Equal(t, 6, rt.ExtraDifficulty(attacker))
+	})
+
+	t.Run("decays_over_time", func(t *testing.T) {
+		now = now.Add(10 * time.Minute)
+		require.Equal(t, 0, rt.ExtraDifficulty(attacker))
+		require.Equal(t, 0, rt.ExtraDifficulty(neighbour))
+	})
+
+	t.Run("well_behaved_client_relaxed", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			rt.ConnectionOpened(polite.Addr)
+			rt.VerificationSucceeded(polite.Addr)
+		}
+		require.Equal(t, -1, rt.ExtraDifficulty(polite))
+	})
+}
+
+func TestReputationTracker_Bounded(t *testing.T) {
+	rt := pow.NewReputationTracker(pow.ReputationConfig{
+		HalfLife:          time.Minute,
+		ConnectionPenalty: 0.25,
+		FailurePenalty:    4,
+		SuccessReward:     1,
+		MaxPenalty:        8,
+		MaxBonus:          2,
+		MaxEntries:        3,
+	}, time.Now)
+
+	rt.VerificationFailed("penalized_1")
+	rt.VerificationFailed("penalized_2")
+	rt.VerificationSucceeded("polite")
+
+	// The flood of new clients evicts the lowest scores and keeps the penalties.
+	for i := 0; i < 1000; i++ {
+		rt.ConnectionOpened(fmt.Sprintf("flood_%v", i))
+	}
+
+	require.Equal(t, 4, rt.ExtraDifficulty(pow.ClientInfo{Addr: "penalized_1"}))
+	require.Equal(t, 4, rt.ExtraDifficulty(pow.ClientInfo{Addr: "penalized_2"}))
+	require.Equal(t, 0, rt.ExtraDifficulty(pow.ClientInfo{Addr: "polite"}))
+	require.Equal(t, 0, rt.ExtraDifficulty(pow.ClientInfo{Addr: "flood_0"}))
+}
+
+func TestReputationTracker_EvictsDecayedScores(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rt := pow.NewReputationTracker(pow.ReputationConfig{
+		HalfLife:       time.Minute,
+		FailurePenalty: 4,
+		MaxPenalty:     8,
+		MaxEntries:     2,
+	}, func() time.Time { return now })
+
+	rt.VerificationFailed("penalized")
+	rt.VerificationFailed("recent")
+
+	// The old penalties decay to 1, the refreshed one is the highest.
+	now = now.Add(2 * time.Minute)
+	rt.VerificationFailed("recent")
+	rt.VerificationFailed("new")
+
+	require.Equal(t, 0, rt.ExtraDifficulty(pow.ClientInfo{Addr: "penalized"}))
+	require.Equal(t, 5, rt.ExtraDifficulty(pow.ClientInfo{Addr: "recent"}))
+	require.Equal(t, 4, rt.ExtraDifficulty(pow.ClientInfo{Addr: "new"}))
+}
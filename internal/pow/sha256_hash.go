@@ -12,3 +12,8 @@ func (s Sha256Hasher) HashData(data []byte) []byte {
 	hash := sha256.Sum256(data)
 	return hash[:]
 }
+
+func (s Sha256Hasher) AppendHash(dst, data []byte) []byte {
+	hash := sha256.Sum256(data)
+	return append(dst, hash[:]...)
+}
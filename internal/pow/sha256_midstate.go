@@ -0,0 +1,232 @@
+package pow
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// midstateDataSize is the challenge data size the midstate solver is specialized for,
+// the data with the nonce and padding fit exactly one SHA-256 block.
+const midstateDataSize = 32
+
+// midstateCtxCheckInterval is the number of hashes between the context checks.
+const midstateCtxCheckInterval = 1 << 12
+
+// Sha256MidstateSolver solves SHA-256 challenges with 32 bytes of data without
+// rehashing the constant part of the block. The nonce is laid out little-endian
+// after the data, so the solver fixes its low 32 bits and iterates the high ones:
+// the first 9 compression rounds and a part of the message schedule depend on the
+// constant words only and are computed once per 2^32 nonces.
+// Other challenges are solved by the parallel solver of the challenger.
+// The compression is pure Go, so it only pays off on CPUs without SHA extensions,
+// crypto/sha256 using SHA-NI is faster than any midstate reuse.
+type Sha256MidstateSolver struct {
+	fallback *ParallelSolver
+}
+
+// NewSha256MidstateSolver returns the solver with workers goroutines, GOMAXPROCS is used
+// when workers isn't positive. The challenger must hash SHA-256 challenges with Sha256Hasher.
+func NewSha256MidstateSolver(challenger *Challenger, workers int) *Sha256MidstateSolver {
+	return &Sha256MidstateSolver{fallback: NewParallelSolver(challenger, workers)}
+}
+
+func (ms *Sha256MidstateSolver) SupportsAlgorithm(name string) bool {
+	return ms.fallback.SupportsAlgorithm(name)
+}
+
+func (ms *Sha256MidstateSolver) SolvePowChallenge(ctx context.Context, challenge Challenge) (uint64, error) {
+	data, err := hex.DecodeString(challenge.Data)
+	if err != nil || len(data) != midstateDataSize || challenge.GetAlgorithm() != AlgorithmSha256 {
+		return ms.fallback.SolvePowChallenge(ctx, challenge)
+	}
+
+	checker, err := newHashChecker(challenge)
+	if err != nil {
+		return ms.fallback.SolvePowChallenge(ctx, challenge)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := ms.fallback.Workers()
+
+	type result struct {
+		nonce uint64
+		err   error
+	}
+
+	results := make(chan result, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(firstLow uint32) {
+			defer wg.Done()
+			nonce, err := solveMidstate(ctx, data, checker, firstLow, uint32(workers))
+			results <- result{nonce: nonce, err: err}
+		}(uint32(i))
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var resErr error
+	for res := range results {
+		if res.err == nil {
+			return res.nonce, nil
+		}
+		resErr = res.err
+	}
+
+	return 0, resErr
+}
+
+// solveMidstate checks the nonces with low 32 bits firstLow, firstLow+lowStep... iterating
+// all the high 32 bits for every low part.
+func solveMidstate(ctx context.Context, data []byte, checker hashChecker, firstLow, lowStep uint32) (uint64, error) {
+	var ms sha256Midstate
+	ms.setData(data)
+
+	var digest [sha256DigestSize]byte
+	for low := uint64(firstLow); low <= math.MaxUint32; low += uint64(lowStep) {
+		ms.setNonceLow(uint32(low))
+
+		for high := uint64(0); high <= math.MaxUint32; high++ {
+			if high%midstateCtxCheckInterval == 0 {
+				select {
+				case <-ctx.Done():
+					return 0, ctx.Err()
+				default:
+				}
+			}
+
+			ms.digest(uint32(high), &digest)
+			if checker.check(digest[:]) {
+				return high<<32 | low, nil
+			}
+		}
+	}
+
+	return 0, ErrNoSolution
+}
+
+const sha256DigestSize = 32
+
+var sha256IV = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+var sha256K = [64]uint32{
+	0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+	0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+	0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+	0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+	0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+	0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+	0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+	0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+}
+
+// sha256Midstate holds the precomputed state of the single block of 32 bytes of data,
+// 8 bytes of little-endian nonce and the padding. The message words are:
+// w[0..7] data, w[8] low nonce half, w[9] high nonce half, w[10] padding bit,
+// w[11..14] zeroes and w[15] the message length in bits.
+type sha256Midstate struct {
+	w [16]uint32
+	// state is the compression state after the rounds 0..8 which don't depend on w[9].
+	state [8]uint32
+	// w17, w19 and w21 don't depend on w[9], k16...k24 are the constant parts of the
+	// schedule words depending on it.
+	w17, w19, w21                uint32
+	k16, k18, k20, k22, k23, k24 uint32
+}
+
+func (ms *sha256Midstate) setData(data []byte) {
+	for i := 0; i < 8; i++ {
+		ms.w[i] = binary.BigEndian.Uint32(data[i*4:])
+	}
+	ms.w[10] = 0x80000000
+	ms.w[15] = (midstateDataSize + nonceSize) * 8
+}
+
+func (ms *sha256Midstate) setNonceLow(low uint32) {
+	w := &ms.w
+	w[8] = bits.ReverseBytes32(low)
+
+	a, b, c, d, e, f, g, h := sha256IV[0], sha256IV[1], sha256IV[2], sha256IV[3],
+		sha256IV[4], sha256IV[5], sha256IV[6], sha256IV[7]
+	for i := 0; i < 9; i++ {
+		t1 := h + bigSigma1(e) + ch(e, f, g) + sha256K[i] + w[i]
+		t2 := bigSigma0(a) + maj(a, b, c)
+		h, g, f, e, d, c, b, a = g, f, e, d+t1, c, b, a, t1+t2
+	}
+	ms.state = [8]uint32{a, b, c, d, e, f, g, h}
+
+	ms.w17 = sigma1(w[15]) + w[10] + sigma0(w[2]) + w[1]
+	ms.w19 = sigma1(ms.w17) + w[12] + sigma0(w[4]) + w[3]
+	ms.w21 = sigma1(ms.w19) + w[14] + sigma0(w[6]) + w[5]
+
+	ms.k16 = sigma1(w[14]) + sigma0(w[1]) + w[0]
+	ms.k18 = w[11] + sigma0(w[3]) + w[2]
+	ms.k20 = w[13] + sigma0(w[5]) + w[4]
+	ms.k22 = w[15] + sigma0(w[7]) + w[6]
+	ms.k23 = sigma1(ms.w21) + sigma0(w[8]) + w[7]
+	ms.k24 = ms.w17 + w[8]
+}
+
+// digest computes the hash of the block with the high nonce half.
+func (ms *sha256Midstate) digest(high uint32, out *[sha256DigestSize]byte) {
+	var w [64]uint32
+	copy(w[:16], ms.w[:])
+	w[9] = bits.ReverseBytes32(high)
+
+	w[16] = ms.k16 + w[9]
+	w[17] = ms.w17
+	w[18] = sigma1(w[16]) + ms.k18
+	w[19] = ms.w19
+	w[20] = sigma1(w[18]) + ms.k20
+	w[21] = ms.w21
+	w[22] = sigma1(w[20]) + ms.k22
+	w[23] = ms.k23 + w[16]
+	w[24] = sigma1(w[22]) + ms.k24 + sigma0(w[9])
+	for i := 25; i < 64; i++ {
+		w[i] = sigma1(w[i-2]) + w[i-7] + sigma0(w[i-15]) + w[i-16]
+	}
+
+	a, b, c, d, e, f, g, h := ms.state[0], ms.state[1], ms.state[2], ms.state[3],
+		ms.state[4], ms.state[5], ms.state[6], ms.state[7]
+	for i := 9; i < 64; i++ {
+		t1 := h + bigSigma1(e) + ch(e, f, g) + sha256K[i] + w[i]
+		t2 := bigSigma0(a) + maj(a, b, c)
+		h, g, f, e, d, c, b, a = g, f, e, d+t1, c, b, a, t1+t2
+	}
+
+	for i, v := range [8]uint32{a, b, c, d, e, f, g, h} {
+		binary.BigEndian.PutUint32(out[i*4:], sha256IV[i]+v)
+	}
+}
+
+func ch(x, y, z uint32) uint32  { return (x & y) ^ (^x & z) }
+func maj(x, y, z uint32) uint32 { return (x & y) ^ (x & z) ^ (y & z) }
+
+func bigSigma0(x uint32) uint32 {
+	return bits.RotateLeft32(x, -2) ^ bits.RotateLeft32(x, -13) ^ bits.RotateLeft32(x, -22)
+}
+
+func bigSigma1(x uint32) uint32 {
+	return bits.RotateLeft32(x, -6) ^ bits.RotateLeft32(x, -11) ^ bits.RotateLeft32(x, -25)
+}
+
+func sigma0(x uint32) uint32 {
+	return bits.RotateLeft32(x, -7) ^ bits.RotateLeft32(x, -18) ^ (x >> 3)
+}
+
+func sigma1(x uint32) uint32 {
+	return bits.RotateLeft32(x, -17) ^ bits.RotateLeft32(x, -19) ^ (x >> 10)
+}
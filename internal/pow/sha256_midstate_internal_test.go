@@ -0,0 +1,30 @@
+package pow
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSha256Midstate_MatchesSha256Hasher(t *testing.T) {
+	hasher := NewSha256Hasher()
+
+	for i := 0; i < 100; i++ {
+		data := make([]byte, midstateDataSize+nonceSize)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+
+		nonce := binary.LittleEndian.Uint64(data[midstateDataSize:])
+
+		var ms sha256Midstate
+		ms.setData(data[:midstateDataSize])
+		ms.setNonceLow(uint32(nonce))
+
+		var digest [sha256DigestSize]byte
+		ms.digest(uint32(nonce>>32), &digest)
+
+		require.Equal(t, hasher.HashData(data), digest[:])
+	}
+}
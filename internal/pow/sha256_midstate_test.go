@@ -0,0 +1,87 @@
+package pow_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+func TestSha256MidstateSolver_SolvePowChallenge(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Opts []pow.ChallengerOption
+		Data int
+	}{
+		{Name: "leading_zero_bits", Data: 32},
+		{Name: "target", Data: 32, Opts: []pow.ChallengerOption{pow.WithTargetMode(pow.NewStaticTarget(3000))}},
+		{Name: "fallback_data_size", Data: 16},
+		{Name: "fallback_argon2", Data: 32, Opts: []pow.ChallengerOption{pow.WithArgon2(testArgon2Params)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			challenger := pow.NewChallenger(
+				pow.NewStaticDifficulty(12),
+				pow.NewRandomDataGenerator(tc.Data),
+				pow.NewSha256Hasher(),
+				tc.Opts...,
+			)
+
+			for _, workers := range []int{1, 3} {
+				solver := pow.NewSha256MidstateSolver(challenger, workers)
+
+				challenge, err := challenger.GenerateChallenge(testClient)
+				require.NoError(t, err)
+
+				nonce, err := solver.SolvePowChallenge(context.Background(), challenge)
+				require.NoError(t, err)
+
+				ok, err := challenger.CheckSolution(challenge, testClient, nonce)
+				require.NoError(t, err)
+				require.True(t, ok)
+			}
+		})
+	}
+}
+
+func TestSha256MidstateSolver_CtxErr(t *testing.T) {
+	solver := pow.NewSha256MidstateSolver(pow.NewChallenger(nil, nil, pow.NewSha256Hasher()), 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := solver.SolvePowChallenge(ctx, pow.Challenge{
+		Data:       "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		Difficulty: 256,
+	})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func BenchmarkSha256MidstateSolver_SolvePowChallenge(b *testing.B) {
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(16),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+	)
+	solver := pow.NewSha256MidstateSolver(challenger, 1)
+
+	b.ReportAllocs()
+
+	var hashes uint64
+	for i := 0; i < b.N; i++ {
+		challenge, err := challenger.GenerateChallenge(testClient)
+		require.NoError(b, err)
+
+		nonce, err := solver.SolvePowChallenge(context.Background(), challenge)
+		if err != nil {
+			b.Fatal(err)
+		}
+		// a single worker iterates the high nonce half
+		hashes += nonce>>32 + 1
+	}
+
+	b.ReportMetric(float64(hashes)/b.Elapsed().Seconds(), "hashes/s")
+}
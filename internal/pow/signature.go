@@ -0,0 +1,67 @@
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	// ErrChallengeRejected is the base error for challenges which can't be accepted by the challenger.
+	ErrChallengeRejected = errors.New("challenge rejected")
+	// ErrChallengeExpired is returned for a challenge redeemed after its expiration time.
+	ErrChallengeExpired = fmt.Errorf("%w: challenge expired", ErrChallengeRejected)
+	// ErrInvalidChallengeSignature is returned for a forged or unsigned challenge.
+	ErrInvalidChallengeSignature = fmt.Errorf("%w: invalid challenge signature", ErrChallengeRejected)
+	// ErrChallengeReplayed is returned for a challenge which solution was already redeemed.
+	ErrChallengeReplayed = fmt.Errorf("%w: challenge already redeemed", ErrChallengeRejected)
+)
+
+type challengeSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func (cs challengeSigner) sign(ch *Challenge, clientAddr string, now time.Time) {
+	ch.IssuedAt = now.Unix()
+	ch.ExpiresAt = now.Add(cs.ttl).Unix()
+	ch.Signature = hex.EncodeToString(cs.mac(*ch, clientAddr))
+}
+
+func (cs challengeSigner) verify(ch Challenge, clientAddr string, now time.Time) error {
+	signature, err := hex.DecodeString(ch.Signature)
+	if err != nil || !hmac.Equal(signature, cs.mac(ch, clientAddr)) {
+		return ErrInvalidChallengeSignature
+	}
+	if now.Unix() > ch.ExpiresAt {
+		return ErrChallengeExpired
+	}
+	return nil
+}
+
+// mac authenticates every challenge field the solution depends on together with the client address.
+func (cs challengeSigner) mac(ch Challenge, clientAddr string) []byte {
+	h := hmac.New(sha256.New, cs.secret)
+	for _, field := range []string{ch.Data, ch.Algorithm, ch.Target, clientAddr} {
+		writeMacField(h, []byte(field))
+	}
+	for _, field := range []int64{int64(ch.Difficulty), ch.IssuedAt, ch.ExpiresAt} {
+		writeMacField(h, binary.BigEndian.AppendUint64(nil, uint64(field)))
+	}
+	if ch.Argon2 != nil {
+		writeMacField(h, binary.BigEndian.AppendUint32(nil, ch.Argon2.Memory))
+		writeMacField(h, binary.BigEndian.AppendUint32(nil, ch.Argon2.Iterations))
+		writeMacField(h, []byte{ch.Argon2.Threads})
+	}
+	return h.Sum(nil)
+}
+
+func writeMacField(w io.Writer, field []byte) {
+	_, _ = w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(field))))
+	_, _ = w.Write(field)
+}
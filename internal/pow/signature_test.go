@@ -0,0 +1,105 @@
+package pow_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+func TestChallenger_SignedChallenge(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(4),
+		pow.NewRandomDataGenerator(32),
+		pow.NewSha256Hasher(),
+		pow.WithHmacSigning([]byte("secret"), time.Minute),
+		pow.WithClock(func() time.Time { return now }),
+	)
+
+	challenge, err := challenger.GenerateChallenge(testClient)
+	require.NoError(t, err)
+	require.Equal(t, now.Unix(), challenge.IssuedAt)
+	require.Equal(t, now.Add(time.Minute).Unix(), challenge.ExpiresAt)
+	require.NotEmpty(t, challenge.Signature)
+
+	nonce, err := challenger.SolvePowChallenge(context.Background(), challenge)
+	require.NoError(t, err)
+
+	t.Run("valid", func(t *testing.T) {
+		ok, err := challenger.CheckSolution(challenge, testClient, nonce)
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("other_server_with_same_secret", func(t *testing.T) {
+		other := pow.NewChallenger(
+			pow.NewStaticDifficulty(4),
+			pow.NewRandomDataGenerator(32),
+			pow.NewSha256Hasher(),
+			pow.WithHmacSigning([]byte("secret"), time.Minute),
+			pow.WithClock(func() time.Time { return now }),
+		)
+		ok, err := other.CheckSolution(challenge, testClient, nonce)
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("other_client_addr", func(t *testing.T) {
+		_, err := challenger.CheckSolution(challenge, pow.ClientInfo{Addr: "10.0.0.1"}, nonce)
+		require.ErrorIs(t, err, pow.ErrInvalidChallengeSignature)
+		require.ErrorIs(t, err, pow.ErrChallengeRejected)
+	})
+
+	t.Run("forged_difficulty", func(t *testing.T) {
+		forged := challenge
+		forged.Difficulty = 0
+		_, err := challenger.CheckSolution(forged, testClient, nonce)
+		require.ErrorIs(t, err, pow.ErrInvalidChallengeSignature)
+	})
+
+	t.Run("forged_expiration", func(t *testing.T) {
+		forged := challenge
+		forged.ExpiresAt += 3600
+		_, err := challenger.CheckSolution(forged, testClient, nonce)
+		require.ErrorIs(t, err, pow.ErrInvalidChallengeSignature)
+	})
+
+	t.Run("other_secret", func(t *testing.T) {
+		other := pow.NewChallenger(
+			pow.NewStaticDifficulty(4),
+			pow.NewRandomDataGenerator(32),
+			pow.NewSha256Hasher(),
+			pow.WithHmacSigning([]byte("other secret"), time.Minute),
+			pow.WithClock(func() time.Time { return now }),
+		)
+		_, err := other.CheckSolution(challenge, testClient, nonce)
+		require.ErrorIs(t, err, pow.ErrInvalidChallengeSignature)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expired := pow.NewChallenger(
+			pow.NewStaticDifficulty(4),
+			pow.NewRandomDataGenerator(32),
+			pow.NewSha256Hasher(),
+			pow.WithHmacSigning([]byte("secret"), time.Minute),
+			pow.WithClock(func() time.Time { return now.Add(2 * time.Minute) }),
+		)
+		_, err := expired.CheckSolution(challenge, testClient, nonce)
+		require.ErrorIs(t, err, pow.ErrChallengeExpired)
+	})
+
+	t.Run("unsigned_challenger", func(t *testing.T) {
+		unsigned := pow.NewChallenger(
+			pow.NewStaticDifficulty(4),
+			pow.NewRandomDataGenerator(32),
+			pow.NewSha256Hasher(),
+		)
+		_, err := unsigned.CheckSolution(challenge, testClient, nonce)
+		require.ErrorIs(t, err, pow.ErrInvalidChallengeSignature)
+	})
+}
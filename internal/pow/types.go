@@ -1,24 +1,252 @@
 package pow
 
-import "strings"
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"time"
+)
+
+type Config struct {
+	// TargetWork enables the target verification mode when positive,
+	// it's the average number of hashes needed to solve a challenge.
+	TargetWork float64 `envconfig:"TARGET_WORK"`
+	// Secret enables HMAC signed stateless challenges when set, servers sharing
+	// the secret accept each other's challenges.
+	Secret       string        `envconfig:"SECRET"`
+	ChallengeTTL time.Duration `envconfig:"CHALLENGE_TTL" default:"5m"`
+	// ReplayCacheSize is the max number of redeemed signed challenges remembered, the new
+	// redemptions are rejected while it is full of the unexpired ones.
+	ReplayCacheSize int `envconfig:"REPLAY_CACHE_SIZE" default:"100000"`
+
+	Adaptive   AdaptiveDifficultyConfig `envconfig:"ADAPTIVE"`
+	Reputation ReputationConfig         `envconfig:"REPUTATION"`
+	Argon2     Argon2Config             `envconfig:"ARGON2"`
+	// HashcashResource enables hashcash v1 stamps minted for the resource, usually the server identity.
+	HashcashResource string        `envconfig:"HASHCASH_RESOURCE"`
+	HashcashWindow   time.Duration `envconfig:"HASHCASH_WINDOW" default:"10m"`
+
+	AccessToken AccessTokenConfig `envconfig:"ACCESS_TOKEN"`
+	// TrustedClientDifficulty caps the difficulty of the clients with the trusted TLS certificate,
+	// it's applied when the server accepts the client certificates.
+	TrustedClientDifficulty int `envconfig:"TRUSTED_CLIENT_DIFFICULTY"`
+}
+
+// ClientInfo is the metadata of the client connection a challenge is generated for.
+type ClientInfo struct {
+	// Addr is the client host without port.
+	Addr string
+	// Trusted is set when the client authenticated with the trusted TLS certificate.
+	Trusted bool
+}
 
 type Challenge struct {
-	Data       string
+	Data string
+	// Algorithm is the PoW algorithm identifier, the empty one means SHA-256.
+	Algorithm string
+	// Difficulty is the required number of leading zero bits of the solution hash.
 	Difficulty int
+	// Target is the hex encoded big-endian 256-bit target, when it is set the
+	// solution hash must be below it and Difficulty is ignored.
+	Target string
+	// IssuedAt and ExpiresAt are unix timestamps of signed challenges.
+	IssuedAt  int64
+	ExpiresAt int64
+	// Signature is the hex encoded HMAC of the challenge bound to the client address.
+	Signature string
+	// Argon2 are the params of the memory-hard Argon2id algorithm.
+	Argon2 *Argon2Params
+	// HashcashResource is set when hashcash v1 stamps for the resource are accepted
+	// instead of the challenge solution.
+	HashcashResource string
+}
+
+// GetAlgorithm returns the challenge algorithm identifier, the challenges without
+// it are treated as SHA-256 ones unless they carry Argon2 params.
+func (ch Challenge) GetAlgorithm() string {
+	switch {
+	case ch.Algorithm != "":
+		return ch.Algorithm
+	case ch.Argon2 != nil:
+		return AlgorithmArgon2id
+	default:
+		return AlgorithmSha256
+	}
+}
+
+// DifficultyBits returns the binary logarithm of the average number of hashes needed to solve
+// the challenge, it's rounded down for the target ones.
+func (ch Challenge) DifficultyBits() int {
+	if ch.Target == "" {
+		return ch.Difficulty
+	}
+	target, ok := new(big.Int).SetString(ch.Target, 16)
+	if !ok || target.Sign() <= 0 {
+		return ch.Difficulty
+	}
+	// The target of 2^n work is 2^(256-n), so it's n bits.
+	return targetBytes*8 - target.Sub(target, big.NewInt(1)).BitLen()
+}
+
+// CheckHash reports whether hash satisfies the challenge difficulty or target.
+func (ch Challenge) CheckHash(hash []byte) bool {
+	checker, err := newHashChecker(ch)
+	if err != nil {
+		return false
+	}
+	return checker.check(hash)
+}
+
+// LeadingZeroBits returns the number of leading zero bits of data.
+func LeadingZeroBits(data []byte) int {
+	var n int
+	for _, b := range data {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// VerificationMode defines how a solution hash is checked against a challenge.
+type VerificationMode int
+
+const (
+	// LeadingZeroBitsMode requires the hash to have Difficulty leading zero bits.
+	LeadingZeroBitsMode VerificationMode = iota
+	// TargetMode requires the hash interpreted as a big-endian integer to be below Target.
+	TargetMode
+)
+
+const targetBytes = 32
+
+// maxTarget is 2^256, the target which every hash satisfies.
+var maxTarget = new(big.Int).Lsh(big.NewInt(1), targetBytes*8)
+
+// TargetForWork returns the target for which a solution takes work hashes on average.
+// Unlike leading zero bits it allows fractional difficulty steps.
+func TargetForWork(work float64) *big.Int {
+	if work <= 1 {
+		return new(big.Int).Sub(maxTarget, big.NewInt(1))
+	}
+	target, _ := new(big.Float).Quo(new(big.Float).SetInt(maxTarget), big.NewFloat(work)).Int(nil)
+	return target
+}
+
+// adjustTarget makes target extraDifficulty times harder in bits, negative
+// values make it easier.
+func adjustTarget(target *big.Int, extraDifficulty int) *big.Int {
+	if extraDifficulty >= 0 {
+		return new(big.Int).Rsh(target, uint(extraDifficulty))
+	}
+	adjusted := new(big.Int).Lsh(target, uint(-extraDifficulty))
+	if adjusted.Cmp(maxTarget) >= 0 {
+		return adjusted.Sub(maxTarget, big.NewInt(1))
+	}
+	return adjusted
 }
 
-func (ch Challenge) GetDifficultyString() string {
-	return strings.Repeat("0", ch.Difficulty)
+// EncodeTarget returns target as a hex string of fixed 32 bytes width.
+func EncodeTarget(target *big.Int) string {
+	return hex.EncodeToString(target.FillBytes(make([]byte, targetBytes)))
+}
+
+// hashChecker compares raw hash bytes with the challenge difficulty or target without allocations.
+type hashChecker struct {
+	difficulty int
+	hasTarget  bool
+	target     [targetBytes]byte
+}
+
+func newHashChecker(ch Challenge) (hashChecker, error) {
+	if ch.Target == "" {
+		return hashChecker{difficulty: ch.Difficulty}, nil
+	}
+
+	if len(ch.Target) != hex.EncodedLen(targetBytes) {
+		return hashChecker{}, fmt.Errorf("invalid target length %v", len(ch.Target))
+	}
+
+	hc := hashChecker{hasTarget: true}
+	if _, err := appendHexString(hc.target[:0], ch.Target); err != nil {
+		return hashChecker{}, fmt.Errorf("decode target hex from string %v error: %w", ch.Target, err)
+	}
+
+	return hc, nil
+}
+
+func (hc hashChecker) check(hash []byte) bool {
+	if !hc.hasTarget {
+		return LeadingZeroBits(hash) >= hc.difficulty
+	}
+	return len(hash) == targetBytes && bytes.Compare(hash, hc.target[:]) < 0
+}
+
+// appendHexString decodes hex string s appending the bytes to dst, unlike
+// hex.DecodeString it doesn't allocate when dst has enough capacity.
+func appendHexString(dst []byte, s string) ([]byte, error) {
+	if len(s)%2 != 0 {
+		return nil, hex.ErrLength
+	}
+	for i := 0; i < len(s); i += 2 {
+		hi, ok := fromHexChar(s[i])
+		if !ok {
+			return nil, hex.InvalidByteError(s[i])
+		}
+		lo, ok := fromHexChar(s[i+1])
+		if !ok {
+			return nil, hex.InvalidByteError(s[i+1])
+		}
+		dst = append(dst, hi<<4|lo)
+	}
+	return dst, nil
+}
+
+func fromHexChar(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
 }
 
 type Hasher interface {
 	HashData(data []byte) []byte
 }
 
+// AppendHasher is implemented by the hashers able to append the hash to dst
+// without allocations, they are preferred in the solving and verification loops.
+type AppendHasher interface {
+	AppendHash(dst, data []byte) []byte
+}
+
 type DifficultyGetter interface {
 	GetDifficulty() int
 }
 
+// DifficultyAdjuster returns the per client difficulty change in bits, it may be negative.
+type DifficultyAdjuster interface {
+	ExtraDifficulty(client ClientInfo) int
+}
+
+type TargetGetter interface {
+	GetTarget() *big.Int
+}
+
+// ReplayCache remembers redeemed challenges, it may be backed by a store shared between servers.
+type ReplayCache interface {
+	// MarkRedeemed records key until expiresAt and reports whether it wasn't recorded before.
+	// It reports false when the key can't be recorded, so the redemption fails closed.
+	MarkRedeemed(key string, expiresAt time.Time) bool
+}
+
 type RandomDataGetter interface {
 	GetRandomDataBytes() ([]byte, error)
 }
@@ -8,18 +8,82 @@ import (
 	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
 )
 
-func TestChallenge_GetDifficultyString(t *testing.T) {
+func TestLeadingZeroBits(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Data     []byte
+		Expected int
+	}{
+		{Name: "empty", Data: nil, Expected: 0},
+		{Name: "no_zero_bits", Data: []byte{0x80, 0x00}, Expected: 0},
+		{Name: "partial_first_byte", Data: []byte{0x1f, 0xff}, Expected: 3},
+		{Name: "whole_first_byte", Data: []byte{0x00, 0x40}, Expected: 9},
+		{Name: "all_zero", Data: []byte{0x00, 0x00}, Expected: 16},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Expected, pow.LeadingZeroBits(tc.Data))
+		})
+	}
+}
+
+func TestChallenge_CheckHash(t *testing.T) {
 	ch := pow.Challenge{
 		Data:       "test",
 		Difficulty: 10,
 	}
 
-	require.Equal(t, "0000000000", ch.GetDifficultyString())
+	require.True(t, ch.CheckHash([]byte{0x00, 0x20}))
+	require.True(t, ch.CheckHash([]byte{0x00, 0x00}))
+	require.False(t, ch.CheckHash([]byte{0x00, 0x40}))
 
 	ch = pow.Challenge{
 		Data:       "test",
 		Difficulty: 1,
 	}
 
-	require.Equal(t, "0", ch.GetDifficultyString())
+	require.True(t, ch.CheckHash([]byte{0x7f}))
+	require.False(t, ch.CheckHash([]byte{0x80}))
+}
+
+func TestChallenge_CheckHashTarget(t *testing.T) {
+	ch := pow.Challenge{
+		Data:   "test",
+		Target: pow.EncodeTarget(pow.TargetForWork(1.5)),
+	}
+
+	require.Equal(t, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", ch.Target)
+
+	below := make([]byte, 32)
+	below[0] = 0xaa
+	require.True(t, ch.CheckHash(below))
+
+	above := make([]byte, 32)
+	above[0] = 0xab
+	require.False(t, ch.CheckHash(above))
+
+	require.False(t, ch.CheckHash([]byte{0x00}))
+
+	ch.Target = "invalid"
+	require.False(t, ch.CheckHash(below))
+}
+
+func TestTargetForWork(t *testing.T) {
+	require.Equal(t,
+		"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		pow.EncodeTarget(pow.TargetForWork(1)),
+	)
+	require.Equal(t,
+		"0000010000000000000000000000000000000000000000000000000000000000",
+		pow.EncodeTarget(pow.TargetForWork(1<<24)),
+	)
+}
+
+func TestChallenge_DifficultyBits(t *testing.T) {
+	require.Equal(t, 20, pow.Challenge{Difficulty: 20}.DifficultyBits())
+	require.Equal(t, 24, pow.Challenge{Target: pow.EncodeTarget(pow.TargetForWork(1 << 24))}.DifficultyBits())
+	require.Equal(t, 24, pow.Challenge{Target: pow.EncodeTarget(pow.TargetForWork(1<<24 + 1000))}.DifficultyBits())
+	require.Equal(t, 0, pow.Challenge{Target: pow.EncodeTarget(pow.TargetForWork(1.5))}.DifficultyBits())
+	require.Equal(t, 7, pow.Challenge{Difficulty: 7, Target: "invalid"}.DifficultyBits())
 }
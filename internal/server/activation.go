@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// listenFDsStart is the first inherited fd, the ones before it are stdin, stdout and stderr.
+	listenFDsStart = 3
+
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+	// envRestartFDs is the number of the listener fds the server hands to the new process on
+	// restart, it doesn't know the pid of the new process to set LISTEN_PID.
+	envRestartFDs = "POW_LISTEN_FDS"
+)
+
+// listenEnv is the environment of the inherited listeners.
+var listenEnv = []string{envListenPID, envListenFDs, envListenFDNames, envRestartFDs}
+
+// InheritedListeners returns the listeners passed with the systemd socket activation protocol
+// or by the server handing them to the new process on restart. It's empty when the process has
+// none. The protocol environment is unset, so it isn't inherited further.
+func InheritedListeners() ([]net.Listener, error) {
+	fds, err := listenFDs(os.Getenv, os.Getpid())
+	for _, key := range listenEnv {
+		os.Unsetenv(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make([]net.Listener, 0, fds)
+	for fd := listenFDsStart; fd < listenFDsStart+fds; fd++ {
+		file := os.NewFile(uintptr(fd), "listen_fd_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("inherit listener of fd %v error: %w", fd, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listenFDs returns the number of the listener fds passed to the process with pid. The systemd
+// fds are accepted only with LISTEN_PID of the process, the stale LISTEN_FDS inherited from
+// another process isn't.
+func listenFDs(getenv func(string) string, pid int) (int, error) {
+	if fdsEnv := getenv(envRestartFDs); fdsEnv != "" {
+		return parseFDs(envRestartFDs, fdsEnv)
+	}
+
+	fdsEnv, pidEnv := getenv(envListenFDs), getenv(envListenPID)
+	if fdsEnv == "" || pidEnv == "" {
+		return 0, nil
+	}
+
+	listenPID, err := strconv.Atoi(pidEnv)
+	if err != nil {
+		return 0, fmt.Errorf("parse %v %q error: %w", envListenPID, pidEnv, err)
+	}
+	if listenPID != pid {
+		return 0, nil
+	}
+
+	return parseFDs(envListenFDs, fdsEnv)
+}
+
+func parseFDs(key, value string) (int, error) {
+	fds, err := strconv.Atoi(value)
+	if err != nil || fds < 0 {
+		return 0, fmt.Errorf("invalid %v %q", key, value)
+	}
+	return fds, nil
+}
+
+// StartWithListeners starts the command inheriting the listeners the server accepts connections
+// on, they're passed like with the socket activation protocol but counted in POW_LISTEN_FDS.
+// Once it's started, the unix sockets aren't removed by the server anymore, they're the command ones.
+func (s *Server) StartWithListeners(cmd *exec.Cmd) error {
+	if len(cmd.ExtraFiles) != 0 {
+		return errors.New("command has extra files, the listener fds would be shifted")
+	}
+
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	if len(s.listeners) == 0 {
+		return errors.New("server has no listeners")
+	}
+
+	files := make([]*os.File, 0, len(s.listeners))
+	for _, listener := range s.listeners {
+		filer, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			closeFiles(files)
+			return fmt.Errorf("listener %v has no file", listener.Addr())
+		}
+		file, err := filer.File()
+		if err != nil {
+			closeFiles(files)
+			return fmt.Errorf("get listener %v file error: %w", listener.Addr(), err)
+		}
+		files = append(files, file)
+	}
+	defer closeFiles(files)
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	env = slices.DeleteFunc(slices.Clone(env), func(kv string) bool {
+		key, _, _ := strings.Cut(kv, "=")
+		return slices.Contains(listenEnv, key)
+	})
+
+	cmd.Env = append(env, envRestartFDs+"="+strconv.Itoa(len(files)))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start command error: %w", err)
+	}
+
+	for _, listener := range s.listeners {
+		if unixListener, ok := listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+	mocks "github.com/nikvakhrameev/pow_tcp_server/mocks/internal_/server"
+)
+
+// envHelperProcess makes the test binary run the server inheriting the listeners.
+const envHelperProcess = "POW_TEST_INHERITED_LISTENERS"
+
+func TestListenFDs(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Env      map[string]string
+		Expected int
+		Err      bool
+	}{
+		{Name: "no_fds", Env: map[string]string{}},
+		{Name: "systemd", Env: map[string]string{envListenFDs: "2", envListenPID: "42"}, Expected: 2},
+		{Name: "parent_server", Env: map[string]string{envRestartFDs: "1"}, Expected: 1},
+		{Name: "other_process", Env: map[string]string{envListenFDs: "2", envListenPID: "7"}},
+		{Name: "stale_fds", Env: map[string]string{envListenFDs: "2"}},
+		{Name: "invalid_fds", Env: map[string]string{envListenFDs: "-1", envListenPID: "42"}, Err: true},
+		{Name: "invalid_restart_fds", Env: map[string]string{envRestartFDs: "fds"}, Err: true},
+		{Name: "invalid_pid", Env: map[string]string{envListenFDs: "1", envListenPID: "pid"}, Err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fds, err := listenFDs(func(key string) string { return tc.Env[key] }, 42)
+			if tc.Err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.Expected, fds)
+		})
+	}
+}
+
+// TestServer_StartWithListeners hands the listener to the test binary process running the server
+// and stops the own server, the connections are accepted by the new process then.
+func TestServer_StartWithListeners(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fds aren't inherited on windows")
+	}
+
+	srv := makeListenServer(t, Config{})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErrChan := make(chan error, 1)
+	go func() {
+		serveErrChan <- srv.Serve(ctx, listener)
+	}()
+
+	requireChallenge(t, "tcp", addr)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperInheritedListeners$")
+	cmd.Env = append(os.Environ(), envHelperProcess+"=1")
+	require.NoError(t, srv.StartWithListeners(cmd))
+	defer func() {
+		require.NoError(t, cmd.Process.Kill())
+		_ = cmd.Wait()
+	}()
+
+	cancel()
+	require.ErrorIs(t, <-serveErrChan, context.Canceled)
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+
+	codec := NewJSONCodec(conn, conn, maxSolutionReadBytes)
+	require.NoError(t, codec.Encode(ClientHello{Versions: []int{ProtocolVersion2}}))
+	var hello ServerHello
+	require.NoError(t, codec.Decode(&hello))
+	var pc PowChallenge
+	require.NoError(t, codec.Decode(&pc))
+	// The new process challenges carry its pid.
+	require.Equal(t, strconv.Itoa(cmd.Process.Pid), pc.Data)
+}
+
+func TestServer_StartWithListenersFailure(t *testing.T) {
+	srv := makeListenServer(t, Config{})
+
+	socket := filepath.Join(t.TempDir(), "pow.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErrChan := make(chan error, 1)
+	go func() {
+		serveErrChan <- srv.Serve(ctx, listener)
+	}()
+
+	requireChallenge(t, "unix", socket)
+
+	cmd := exec.Command(filepath.Join(t.TempDir(), "missing"))
+	require.ErrorContains(t, srv.StartWithListeners(cmd), "start command error")
+
+	// The server keeps the socket it serves, so it's removed on the shutdown.
+	cancel()
+	require.ErrorIs(t, <-serveErrChan, context.Canceled)
+	require.NoFileExists(t, socket)
+}
+
+// TestHelperInheritedListeners isn't a test, it's the process the listeners are passed to.
+func TestHelperInheritedListeners(t *testing.T) {
+	if os.Getenv(envHelperProcess) == "" {
+		t.Skip("the helper process of TestServer_StartWithListeners")
+	}
+
+	mockDdosProtector := mocks.NewDdosProtector(t)
+	mockDdosProtector.On("GenerateChallenge", mock.Anything).
+		Return(pow.Challenge{Data: strconv.Itoa(os.Getpid()), Difficulty: 10}, nil).Maybe()
+	srv := NewServer(
+		Config{ProtocolProbeTimeout: 100 * time.Millisecond, HandleConnectionTimeout: 10 * time.Second},
+		mockDdosProtector,
+		mocks.NewWisdomQuotesGetter(t),
+		slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.ErrorIs(t, srv.Run(ctx), context.DeadlineExceeded)
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+func (ClientHello) messageType() MessageType { return MessageClientHello }
+
+// The fields added after the message was introduced are appended only when they're set,
+// so the peers which don't use them decode the message as before.
+
+func (h ClientHello) appendBinary(b []byte) []byte {
+	b = appendInts(b, h.Versions)
+	b = appendStrings(b, h.Features)
+	if h.Token != "" {
+		b = appendString(b, h.Token)
+	}
+	return b
+}
+
+func (h *ClientHello) unmarshalBinary(data []byte) error {
+	r := payloadReader{data: data}
+	*h = ClientHello{Versions: r.ints(), Features: r.strings()}
+	if r.more() {
+		h.Token = r.string()
+	}
+	return r.finish()
+}
+
+func (ServerHello) messageType() MessageType { return MessageServerHello }
+
+func (h ServerHello) appendBinary(b []byte) []byte {
+	b = binary.AppendVarint(b, int64(h.Version))
+	b = appendStrings(b, h.Features)
+	if h.TokenAccepted {
+		b = append(b, 1)
+	}
+	return b
+}
+
+func (h *ServerHello) unmarshalBinary(data []byte) error {
+	r := payloadReader{data: data}
+	*h = ServerHello{Version: int(r.varint(math.MinInt32, math.MaxInt32)), Features: r.strings()}
+	if r.more() {
+		h.TokenAccepted = r.flag()
+	}
+	return r.finish()
+}
+
+func (PowChallenge) messageType() MessageType { return MessageChallenge }
+
+func (pc PowChallenge) appendBinary(b []byte) []byte {
+	b = appendString(b, pc.Data)
+	b = appendString(b, pc.Algorithm)
+	b = binary.AppendVarint(b, int64(pc.Difficulty))
+	b = appendString(b, pc.Target)
+	b = binary.AppendVarint(b, pc.IssuedAt)
+	b = binary.AppendVarint(b, pc.ExpiresAt)
+	b = appendString(b, pc.Signature)
+	if pc.Argon2 == nil {
+		b = append(b, 0)
+	} else {
+		b = append(b, 1)
+		b = binary.AppendUvarint(b, uint64(pc.Argon2.Memory))
+		b = binary.AppendUvarint(b, uint64(pc.Argon2.Iterations))
+		b = append(b, pc.Argon2.Threads)
+	}
+	return appendString(b, pc.HashcashResource)
+}
+
+func (pc *PowChallenge) unmarshalBinary(data []byte) error {
+	r := payloadReader{data: data}
+	pc.readFrom(&r)
+	return r.finish()
+}
+
+func (pc *PowChallenge) readFrom(r *payloadReader) {
+	*pc = PowChallenge{
+		Data:       r.string(),
+		Algorithm:  r.string(),
+		Difficulty: int(r.varint(math.MinInt32, math.MaxInt32)),
+		Target:     r.string(),
+		IssuedAt:   r.varint(math.MinInt64, math.MaxInt64),
+		ExpiresAt:  r.varint(math.MinInt64, math.MaxInt64),
+		Signature:  r.string(),
+	}
+	if r.flag() {
+		pc.Argon2 = &pow.Argon2Params{
+			Memory:     uint32(r.uvarint(math.MaxUint32)),
+			Iterations: uint32(r.uvarint(math.MaxUint32)),
+			Threads:    r.byte(),
+		}
+	}
+	pc.HashcashResource = r.string()
+}
+
+func (PowChallengeSolution) messageType() MessageType { return MessageSolution }
+
+func (ps PowChallengeSolution) appendBinary(b []byte) []byte {
+	b = binary.BigEndian.AppendUint64(b, ps.Nonce)
+	if ps.Challenge == nil {
+		b = append(b, 0)
+	} else {
+		b = append(b, 1)
+		b = ps.Challenge.appendBinary(b)
+	}
+	return appendString(b, ps.Stamp)
+}
+
+func (ps *PowChallengeSolution) unmarshalBinary(data []byte) error {
+	r := payloadReader{data: data}
+	*ps = PowChallengeSolution{Nonce: r.uint64()}
+	if r.flag() {
+		ps.Challenge = new(PowChallenge)
+		ps.Challenge.readFrom(&r)
+	}
+	ps.Stamp = r.string()
+	return r.finish()
+}
+
+func (WordOfWisdom) messageType() MessageType { return MessageResult }
+
+func (w WordOfWisdom) appendBinary(b []byte) []byte {
+	b = appendString(b, w.Text)
+	if w.Token != "" {
+		b = appendString(b, w.Token)
+		b = binary.AppendVarint(b, w.TokenExpiresAt)
+	}
+	return b
+}
+
+func (w *WordOfWisdom) unmarshalBinary(data []byte) error {
+	r := payloadReader{data: data}
+	*w = WordOfWisdom{Text: r.string()}
+	if r.more() {
+		w.Token = r.string()
+		w.TokenExpiresAt = r.varint(math.MinInt64, math.MaxInt64)
+	}
+	return r.finish()
+}
+
+func (QuoteRequest) messageType() MessageType { return MessageQuoteRequest }
+
+func (QuoteRequest) appendBinary(b []byte) []byte {
+	return b
+}
+
+func (req *QuoteRequest) unmarshalBinary(data []byte) error {
+	r := payloadReader{data: data}
+	return r.finish()
+}
+
+func (ErrorMessage) messageType() MessageType { return MessageError }
+
+func (em ErrorMessage) appendBinary(b []byte) []byte {
+	b = appendString(b, string(em.Code))
+	return appendString(b, em.Message)
+}
+
+func (em *ErrorMessage) unmarshalBinary(data []byte) error {
+	r := payloadReader{data: data}
+	*em = ErrorMessage{Code: ErrorCode(r.string()), Message: r.string()}
+	return r.finish()
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendInts(b []byte, values []int) []byte {
+	b = binary.AppendUvarint(b, uint64(len(values)))
+	for _, v := range values {
+		b = binary.AppendVarint(b, int64(v))
+	}
+	return b
+}
+
+func appendStrings(b []byte, values []string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(values)))
+	for _, v := range values {
+		b = appendString(b, v)
+	}
+	return b
+}
+
+// payloadReader decodes the binary payload fields, the first error stops the decoding
+// and is reported by finish.
+type payloadReader struct {
+	data []byte
+	err  error
+}
+
+func (r *payloadReader) finish() error {
+	if r.err == nil && len(r.data) != 0 {
+		r.fail("%v trailing bytes", len(r.data))
+	}
+	return r.err
+}
+
+func (r *payloadReader) fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: "+format, append([]any{ErrMalformedMessage}, args...)...)
+	}
+	r.data = nil
+}
+
+// more reports whether the payload has the optional fields left.
+func (r *payloadReader) more() bool {
+	return r.err == nil && len(r.data) > 0
+}
+
+func (r *payloadReader) byte() byte {
+	if len(r.data) < 1 {
+		r.fail("unexpected end of payload")
+		return 0
+	}
+	v := r.data[0]
+	r.data = r.data[1:]
+	return v
+}
+
+func (r *payloadReader) flag() bool {
+	switch v := r.byte(); v {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		r.fail("invalid flag %v", v)
+		return false
+	}
+}
+
+func (r *payloadReader) uint64() uint64 {
+	if len(r.data) < 8 {
+		r.fail("unexpected end of payload")
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v
+}
+
+func (r *payloadReader) uvarint(maxValue uint64) uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 || v > maxValue {
+		r.fail("invalid unsigned varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *payloadReader) varint(minValue, maxValue int64) int64 {
+	v, n := binary.Varint(r.data)
+	if n <= 0 || v < minValue || v > maxValue {
+		r.fail("invalid varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *payloadReader) string() string {
+	size := r.uvarint(math.MaxInt32)
+	if r.err != nil {
+		return ""
+	}
+	if size > uint64(len(r.data)) {
+		r.fail("string of %v bytes exceeds payload", size)
+		return ""
+	}
+	s := string(r.data[:size])
+	r.data = r.data[size:]
+	return s
+}
+
+func (r *payloadReader) ints() []int {
+	// Every value takes one byte at least, so the count is limited by the payload size.
+	count := r.uvarint(uint64(len(r.data)))
+	if count == 0 {
+		return nil
+	}
+	values := make([]int, 0, count)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		values = append(values, int(r.varint(math.MinInt32, math.MaxInt32)))
+	}
+	return values
+}
+
+func (r *payloadReader) strings() []string {
+	count := r.uvarint(uint64(len(r.data)))
+	if count == 0 {
+		return nil
+	}
+	values := make([]string, 0, count)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		values = append(values, r.string())
+	}
+	return values
+}
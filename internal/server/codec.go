@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BinaryMagic is the first byte sent by the clients speaking the binary protocol. The JSON
+// clients never send anything before the challenge, so the byte selects the framing.
+const BinaryMagic byte = 0xb5
+
+// binaryVersion is the version of the binary framing, every frame starts with it.
+const binaryVersion byte = 1
+
+// binaryHeaderSize is the size of the version, message type and payload length.
+const binaryHeaderSize = 6
+
+type MessageType byte
+
+const (
+	MessageChallenge MessageType = iota + 1
+	MessageSolution
+	MessageResult
+	MessageError
+	MessageClientHello
+	MessageServerHello
+	MessageQuoteRequest
+)
+
+var (
+	ErrUnsupportedFrameVersion = errors.New("unsupported frame version")
+	ErrUnexpectedMessage       = errors.New("unexpected message type")
+	ErrMessageTooLarge         = errors.New("message too large")
+	ErrMalformedMessage        = errors.New("malformed message")
+)
+
+// Message is a protocol message which can be encoded with every codec.
+type Message interface {
+	messageType() MessageType
+	appendBinary(b []byte) []byte
+}
+
+// DecodableMessage is a pointer to the message decoded in place.
+type DecodableMessage interface {
+	Message
+	unmarshalBinary(data []byte) error
+}
+
+// Codec reads and writes the protocol messages on a connection.
+type Codec interface {
+	Encode(msg Message) error
+	// Decode reads the next message into msg. The error message sent by the peer
+	// is returned as the ErrorMessage error.
+	Decode(msg DecodableMessage) error
+	// Buffered returns the data read from the connection past the last decoded message,
+	// it's the start of the stream following the protocol messages.
+	Buffered() io.Reader
+}
+
+// jsonCodec is the newline-delimited JSON protocol of the first clients.
+type jsonCodec struct {
+	enc      *json.Encoder
+	dec      *json.Decoder
+	limit    *io.LimitedReader
+	maxBytes int64
+}
+
+// NewJSONCodec returns the JSON codec reading at most maxBytes per message.
+func NewJSONCodec(r io.Reader, w io.Writer, maxBytes int64) Codec {
+	limit := &io.LimitedReader{R: r}
+	return &jsonCodec{
+		enc:      json.NewEncoder(w),
+		dec:      json.NewDecoder(limit),
+		limit:    limit,
+		maxBytes: maxBytes,
+	}
+}
+
+func (c *jsonCodec) Encode(msg Message) error {
+	return c.enc.Encode(msg)
+}
+
+func (c *jsonCodec) Decode(msg DecodableMessage) error {
+	c.limit.N = c.maxBytes
+
+	var raw json.RawMessage
+	if err := c.dec.Decode(&raw); err != nil {
+		return err
+	}
+
+	if msg.messageType() != MessageError {
+		var errMsg ErrorMessage
+		if err := json.Unmarshal(raw, &errMsg); err == nil && (errMsg.Code != "" || errMsg.Message != "") {
+			return errMsg
+		}
+	}
+
+	return json.Unmarshal(raw, msg)
+}
+
+// Buffered returns the data buffered by the decoder without the newline delimiting the last message.
+func (c *jsonCodec) Buffered() io.Reader {
+	buffered, _ := io.ReadAll(c.dec.Buffered())
+	if rest, ok := bytes.CutPrefix(buffered, []byte("\r\n")); ok {
+		return bytes.NewReader(rest)
+	}
+	return bytes.NewReader(bytes.TrimPrefix(buffered, []byte("\n")))
+}
+
+// binaryCodec frames every message as version, message type, big endian uint32 payload
+// length and payload.
+type binaryCodec struct {
+	r        io.Reader
+	w        io.Writer
+	maxBytes int
+	buf      []byte
+}
+
+// NewBinaryCodec returns the binary codec reading at most maxBytes payload per message.
+// The magic byte is expected to be already exchanged.
+func NewBinaryCodec(r io.Reader, w io.Writer, maxBytes int) Codec {
+	return &binaryCodec{r: r, w: w, maxBytes: maxBytes}
+}
+
+func (c *binaryCodec) Encode(msg Message) error {
+	c.buf = append(c.buf[:0], binaryVersion, byte(msg.messageType()), 0, 0, 0, 0)
+	c.buf = msg.appendBinary(c.buf)
+	binary.BigEndian.PutUint32(c.buf[2:binaryHeaderSize], uint32(len(c.buf)-binaryHeaderSize))
+
+	if _, err := c.w.Write(c.buf); err != nil {
+		return fmt.Errorf("write frame error: %w", err)
+	}
+	return nil
+}
+
+func (c *binaryCodec) Decode(msg DecodableMessage) error {
+	msgType, payload, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+
+	if msgType == MessageError && msg.messageType() != MessageError {
+		var errMsg ErrorMessage
+		if err := errMsg.unmarshalBinary(payload); err != nil {
+			return err
+		}
+		return errMsg
+	}
+
+	if msgType != msg.messageType() {
+		return fmt.Errorf("%w: got %v instead of %v", ErrUnexpectedMessage, msgType, msg.messageType())
+	}
+
+	return msg.unmarshalBinary(payload)
+}
+
+// Buffered returns nothing as the frames are read exactly.
+func (c *binaryCodec) Buffered() io.Reader {
+	return bytes.NewReader(nil)
+}
+
+func (c *binaryCodec) readFrame() (MessageType, []byte, error) {
+	var header [binaryHeaderSize]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, fmt.Errorf("read frame header error: %w", err)
+	}
+
+	if header[0] != binaryVersion {
+		return 0, nil, fmt.Errorf("%w: %v", ErrUnsupportedFrameVersion, header[0])
+	}
+
+	size := binary.BigEndian.Uint32(header[2:])
+	if uint64(size) > uint64(c.maxBytes) {
+		return 0, nil, fmt.Errorf("%w: %v bytes", ErrMessageTooLarge, size)
+	}
+
+	if cap(c.buf) < int(size) {
+		c.buf = make([]byte, size)
+	}
+	payload := c.buf[:size]
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, fmt.Errorf("read frame payload error: %w", err)
+	}
+
+	return MessageType(header[1]), payload, nil
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+var testChallenge = PowChallenge{
+	Data:             "48656c6c6f20476f7068657221",
+	Algorithm:        pow.AlgorithmArgon2id,
+	Difficulty:       12,
+	IssuedAt:         1700000000,
+	ExpiresAt:        1700000300,
+	Signature:        "c2lnbmF0dXJl",
+	Argon2:           &pow.Argon2Params{Memory: 8192, Iterations: 1, Threads: 1},
+	HashcashResource: "pow.example.com",
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	codecs := map[string]func(buf *bytes.Buffer) Codec{
+		"json": func(buf *bytes.Buffer) Codec {
+			return NewJSONCodec(buf, buf, maxSolutionReadBytes)
+		},
+		"binary": func(buf *bytes.Buffer) Codec {
+			return NewBinaryCodec(buf, buf, maxSolutionReadBytes)
+		},
+	}
+
+	for name, newCodec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			codec := newCodec(new(bytes.Buffer))
+
+			require.NoError(t, codec.Encode(testChallenge))
+			var challenge PowChallenge
+			require.NoError(t, codec.Decode(&challenge))
+			require.Equal(t, testChallenge, challenge)
+
+			solution := PowChallengeSolution{Nonce: 1 << 40, Challenge: &testChallenge, Stamp: "1:20:060408:a::b:c"}
+			require.NoError(t, codec.Encode(solution))
+			var decodedSolution PowChallengeSolution
+			require.NoError(t, codec.Decode(&decodedSolution))
+			require.Equal(t, solution, decodedSolution)
+
+			clientHello := ClientHello{Versions: []int{1, 2}, Features: []string{FeatureRedeem, FeatureHashcash}, Token: "token"}
+			require.NoError(t, codec.Encode(clientHello))
+			var decodedClientHello ClientHello
+			require.NoError(t, codec.Decode(&decodedClientHello))
+			require.Equal(t, clientHello, decodedClientHello)
+
+			serverHello := ServerHello{Version: 2, Features: []string{FeatureRedeem}, TokenAccepted: true}
+			require.NoError(t, codec.Encode(serverHello))
+			var decodedServerHello ServerHello
+			require.NoError(t, codec.Decode(&decodedServerHello))
+			require.Equal(t, serverHello, decodedServerHello)
+
+			require.NoError(t, codec.Encode(ErrorMessage{Code: CodeRateLimited, Message: "rejected"}))
+			err := codec.Decode(&decodedServerHello)
+			require.Equal(t, ErrorMessage{Code: CodeRateLimited, Message: "rejected"}, err)
+
+			for _, testWow := range []WordOfWisdom{
+				{Text: "test quote"},
+				{Text: "test quote", Token: "token", TokenExpiresAt: 1700000000},
+			} {
+				require.NoError(t, codec.Encode(testWow))
+				var wow WordOfWisdom
+				require.NoError(t, codec.Decode(&wow))
+				require.Equal(t, testWow, wow)
+			}
+		})
+	}
+}
+
+func TestCodec_Buffered(t *testing.T) {
+	codecs := map[string]func(buf *bytes.Buffer) Codec{
+		"json": func(buf *bytes.Buffer) Codec {
+			return NewJSONCodec(buf, buf, maxSolutionReadBytes)
+		},
+		"binary": func(buf *bytes.Buffer) Codec {
+			return NewBinaryCodec(buf, buf, maxSolutionReadBytes)
+		},
+	}
+
+	for name, newCodec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			codec := newCodec(buf)
+
+			require.NoError(t, codec.Encode(WordOfWisdom{Text: "test quote"}))
+			buf.WriteString("PING\r\n")
+
+			var wow WordOfWisdom
+			require.NoError(t, codec.Decode(&wow))
+
+			rest, err := io.ReadAll(io.MultiReader(codec.Buffered(), buf))
+			require.NoError(t, err)
+			require.Equal(t, "PING\r\n", string(rest))
+		})
+	}
+}
+
+func TestBinaryCodec_DecodeErrorMessage(t *testing.T) {
+	buf := new(bytes.Buffer)
+	codec := NewBinaryCodec(buf, buf, maxSolutionReadBytes)
+
+	require.NoError(t, codec.Encode(ErrorMessage{Code: CodeRateLimited, Message: "rejected"}))
+
+	var wow WordOfWisdom
+	err := codec.Decode(&wow)
+	require.ErrorAs(t, err, new(ErrorMessage))
+	require.Equal(t, ErrorMessage{Code: CodeRateLimited, Message: "rejected"}, err)
+}
+
+func TestBinaryCodec_DecodeError(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Frame []byte
+		Err   error
+	}{
+		{
+			Name:  "unsupported_version",
+			Frame: []byte{2, byte(MessageResult), 0, 0, 0, 1, 0},
+			Err:   ErrUnsupportedFrameVersion,
+		},
+		{
+			Name:  "unexpected_message",
+			Frame: []byte{binaryVersion, byte(MessageChallenge), 0, 0, 0, 1, 0},
+			Err:   ErrUnexpectedMessage,
+		},
+		{
+			Name:  "too_large",
+			Frame: []byte{binaryVersion, byte(MessageResult), 0xff, 0xff, 0xff, 0xff},
+			Err:   ErrMessageTooLarge,
+		},
+		{
+			Name:  "string_exceeds_payload",
+			Frame: []byte{binaryVersion, byte(MessageResult), 0, 0, 0, 2, 5, 'a'},
+			Err:   ErrMalformedMessage,
+		},
+		{
+			Name:  "trailing_bytes",
+			Frame: []byte{binaryVersion, byte(MessageResult), 0, 0, 0, 2, 0, 'a'},
+			Err:   ErrMalformedMessage,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			codec := NewBinaryCodec(bytes.NewReader(tc.Frame), nil, maxSolutionReadBytes)
+
+			var wow WordOfWisdom
+			require.ErrorIs(t, codec.Decode(&wow), tc.Err)
+		})
+	}
+}
+
+func FuzzBinaryCodec_DecodeHello(f *testing.F) {
+	buf := new(bytes.Buffer)
+	require.NoError(f, NewBinaryCodec(buf, buf, maxSolutionReadBytes).Encode(ClientHello{
+		Versions: SupportedVersions,
+		Features: SupportedFeatures,
+	}))
+	f.Add(buf.Bytes())
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		var hello ClientHello
+		if err := NewBinaryCodec(bytes.NewReader(frame), nil, maxSolutionReadBytes).Decode(&hello); err != nil {
+			return
+		}
+
+		buf := new(bytes.Buffer)
+		codec := NewBinaryCodec(buf, buf, maxSolutionReadBytes)
+		require.NoError(t, codec.Encode(hello))
+
+		var decoded ClientHello
+		require.NoError(t, codec.Decode(&decoded))
+		require.Equal(t, hello, decoded)
+	})
+}
+
+func FuzzBinaryCodec_Decode(f *testing.F) {
+	buf := new(bytes.Buffer)
+	seedCodec := NewBinaryCodec(buf, buf, maxSolutionReadBytes)
+	for _, msg := range []Message{
+		PowChallengeSolution{Nonce: 10},
+		PowChallengeSolution{Nonce: 10, Challenge: &testChallenge},
+		PowChallengeSolution{Stamp: "1:20:060408:a::b:c"},
+		testChallenge,
+	} {
+		require.NoError(f, seedCodec.Encode(msg))
+		f.Add(bytes.Clone(buf.Bytes()))
+		buf.Reset()
+	}
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		var solution PowChallengeSolution
+		if err := NewBinaryCodec(bytes.NewReader(frame), nil, maxSolutionReadBytes).Decode(&solution); err != nil {
+			return
+		}
+
+		buf := new(bytes.Buffer)
+		codec := NewBinaryCodec(buf, buf, maxSolutionReadBytes)
+		require.NoError(t, codec.Encode(solution))
+
+		var decoded PowChallengeSolution
+		require.NoError(t, codec.Decode(&decoded))
+		require.Equal(t, solution, decoded)
+	})
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// connLimiter caps the in-flight connections in total and per client subnet. The connections
+// over the total limit may wait in the bounded queue for the released ones.
+type connLimiter struct {
+	maxTotal     int
+	maxPerSubnet int
+	v4Bits       int
+	v6Bits       int
+	queueSize    int
+	// unixLimited applies the per client limit to the unix socket clients.
+	unixLimited bool
+
+	mu      sync.Mutex
+	total   int
+	subnets map[string]int
+	queued  int
+	// released is closed and replaced on every release to wake up the queued connections.
+	released chan struct{}
+}
+
+func newConnLimiter(cfg Config) *connLimiter {
+	return &connLimiter{
+		maxTotal:     cfg.MaxConnections,
+		maxPerSubnet: cfg.MaxConnectionsPerIP,
+		v4Bits:       cfg.ConnectionLimitV4Bits,
+		v6Bits:       cfg.ConnectionLimitV6Bits,
+		queueSize:    cfg.ConnectionQueueSize,
+		unixLimited:  cfg.UnixClientLimits,
+		subnets:      make(map[string]int),
+		released:     make(chan struct{}),
+	}
+}
+
+// acquire takes the connection slot of the client. The code is empty when the slot is
+// taken and tells which limit is exceeded otherwise.
+func (l *connLimiter) acquire(clientAddr string) ErrorCode {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.acquireLocked(clientAddr)
+}
+
+// enqueue reserves the place in the queue, it's false when the queue is full. The connection
+// waits for the slot with wait then.
+func (l *connLimiter) enqueue() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.queued >= l.queueSize {
+		return false
+	}
+	l.queued++
+	return true
+}
+
+// wait takes the connection slot of the client waiting up to timeout for the total limit,
+// it frees the place in the queue reserved with enqueue.
+func (l *connLimiter) wait(ctx context.Context, clientAddr string, timeout time.Duration) ErrorCode {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	defer func() { l.queued-- }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		code := l.acquireLocked(clientAddr)
+		if code != CodeServerOverloaded {
+			return code
+		}
+
+		released := l.released
+		l.mu.Unlock()
+		select {
+		case <-released:
+			l.mu.Lock()
+		case <-timer.C:
+			l.mu.Lock()
+			return CodeServerOverloaded
+		case <-ctx.Done():
+			l.mu.Lock()
+			return CodeServerOverloaded
+		}
+	}
+}
+
+func (l *connLimiter) release(clientAddr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perClient(clientAddr) {
+		subnet := l.subnet(clientAddr)
+		if l.subnets[subnet]--; l.subnets[subnet] <= 0 {
+			delete(l.subnets, subnet)
+		}
+	}
+
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+func (l *connLimiter) acquireLocked(clientAddr string) ErrorCode {
+	if l.maxTotal > 0 && l.total >= l.maxTotal {
+		return CodeServerOverloaded
+	}
+
+	if l.perClient(clientAddr) {
+		subnet := l.subnet(clientAddr)
+		if l.subnets[subnet] >= l.maxPerSubnet {
+			return CodeRateLimited
+		}
+		l.subnets[subnet]++
+	}
+
+	l.total++
+	return ""
+}
+
+// perClient reports whether the per client limit applies to the client.
+func (l *connLimiter) perClient(clientAddr string) bool {
+	return l.maxPerSubnet > 0 && (l.unixLimited || !isUnixClient(clientAddr))
+}
+
+// subnet returns the client subnet the per client limit is applied to, the addresses
+// which aren't IPs are limited on their own.
+func (l *connLimiter) subnet(clientAddr string) string {
+	addr, err := netip.ParseAddr(clientAddr)
+	if err != nil {
+		return clientAddr
+	}
+	addr = addr.Unmap()
+
+	bits := l.v6Bits
+	if addr.Is4() {
+		bits = l.v4Bits
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String()
+	}
+	return prefix.String()
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLimiter_Acquire(t *testing.T) {
+	l := newConnLimiter(Config{
+		MaxConnections:        4,
+		MaxConnectionsPerIP:   2,
+		ConnectionLimitV4Bits: 24,
+		ConnectionLimitV6Bits: 64,
+	})
+
+	require.Equal(t, ErrorCode(""), l.acquire("10.0.0.1"))
+	require.Equal(t, ErrorCode(""), l.acquire("10.0.0.2"))
+	require.Equal(t, CodeRateLimited, l.acquire("10.0.0.3"))
+	require.Equal(t, CodeRateLimited, l.acquire("::ffff:10.0.0.3"))
+
+	require.Equal(t, ErrorCode(""), l.acquire("2001:db8::1"))
+	require.Equal(t, ErrorCode(""), l.acquire("2001:db8::2"))
+	require.Equal(t, CodeServerOverloaded, l.acquire("10.0.1.1"))
+
+	l.release("10.0.0.1")
+	require.Equal(t, ErrorCode(""), l.acquire("10.0.0.3"))
+	require.Equal(t, CodeServerOverloaded, l.acquire("pipe"))
+
+	l.release("2001:db8::1")
+	require.Equal(t, ErrorCode(""), l.acquire("pipe"))
+}
+
+func TestConnLimiter_AcquireUnix(t *testing.T) {
+	l := newConnLimiter(Config{MaxConnectionsPerIP: 1})
+	require.Equal(t, ErrorCode(""), l.acquire("unix:/run/pow.sock"))
+	require.Equal(t, ErrorCode(""), l.acquire("unix:/run/pow.sock"))
+
+	l = newConnLimiter(Config{MaxConnectionsPerIP: 1, UnixClientLimits: true})
+	require.Equal(t, ErrorCode(""), l.acquire("unix:/run/pow.sock"))
+	require.Equal(t, CodeRateLimited, l.acquire("unix:/run/pow.sock"))
+
+	l.release("unix:/run/pow.sock")
+	require.Equal(t, ErrorCode(""), l.acquire("unix:/run/pow.sock"))
+}
+
+func TestConnLimiter_Wait(t *testing.T) {
+	l := newConnLimiter(Config{MaxConnections: 1, ConnectionQueueSize: 1})
+	require.Equal(t, ErrorCode(""), l.acquire("10.0.0.1"))
+
+	require.True(t, l.enqueue())
+	require.Equal(t, CodeServerOverloaded, l.wait(context.Background(), "10.0.0.2", 10*time.Millisecond))
+
+	require.True(t, l.enqueue())
+	waitResult := make(chan ErrorCode)
+	go func() {
+		waitResult <- l.wait(context.Background(), "10.0.0.2", 10*time.Second)
+	}()
+
+	// The queue is full while the other connection waits.
+	require.False(t, l.enqueue())
+
+	l.release("10.0.0.1")
+	require.Equal(t, ErrorCode(""), <-waitResult)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"slices"
+)
+
+const (
+	// ProtocolVersion1 is the exchange of the clients which don't send the hello:
+	// challenge, solution and result.
+	ProtocolVersion1 = 1
+	// ProtocolVersion2 starts with the hello exchange, the rejected connections get
+	// the error message before closing.
+	ProtocolVersion2 = 2
+)
+
+// SupportedVersions are the protocol versions the server speaks.
+var SupportedVersions = []int{ProtocolVersion1, ProtocolVersion2}
+
+const (
+	// FeatureRedeem is the solutions of the signed challenges issued on another connection.
+	FeatureRedeem = "redeem"
+	// FeatureHashcash is the hashcash v1 stamps sent instead of the solution.
+	FeatureHashcash = "hashcash"
+	// FeatureSession is the quote requests after the first quote on the verified connection.
+	FeatureSession = "session"
+	// FeatureAccessToken is the access tokens issued on the solved challenges.
+	FeatureAccessToken = "access_token"
+	// FeatureUpstream is the connection spliced to the upstream after the result, it's required
+	// by the server in the reverse proxy mode.
+	FeatureUpstream = "upstream"
+)
+
+// SupportedFeatures are the protocol features the server speaks, the optional ones are
+// advertised only when they're enabled.
+var SupportedFeatures = []string{FeatureRedeem, FeatureHashcash, FeatureSession, FeatureAccessToken, FeatureUpstream}
+
+// ErrIncompatibleProtocol is returned when the peers have no common protocol version.
+var ErrIncompatibleProtocol = errors.New("incompatible protocol")
+
+// ErrFeatureNotAgreed is returned when the peer uses the feature which wasn't agreed in the hello.
+var ErrFeatureNotAgreed = errors.New("feature not agreed")
+
+// NegotiateVersion returns the highest version supported by both peers.
+func NegotiateVersion(own, peer []int) (int, bool) {
+	version, ok := 0, false
+	for _, v := range peer {
+		if v > version && slices.Contains(own, v) {
+			version, ok = v, true
+		}
+	}
+	return version, ok
+}
+
+// CommonFeatures returns the features of own supported by the peer too.
+func CommonFeatures(own, peer []string) []string {
+	var common []string
+	for _, feature := range own {
+		if slices.Contains(peer, feature) {
+			common = append(common, feature)
+		}
+	}
+	return common
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	version, ok := NegotiateVersion([]int{1, 2}, []int{2, 3})
+	require.True(t, ok)
+	require.Equal(t, 2, version)
+
+	version, ok = NegotiateVersion([]int{1, 2, 3}, []int{3, 1})
+	require.True(t, ok)
+	require.Equal(t, 3, version)
+
+	_, ok = NegotiateVersion([]int{1, 2}, []int{3, 4})
+	require.False(t, ok)
+
+	_, ok = NegotiateVersion([]int{1, 2}, nil)
+	require.False(t, ok)
+}
+
+func TestCommonFeatures(t *testing.T) {
+	require.Equal(t, []string{FeatureHashcash}, CommonFeatures(SupportedFeatures, []string{"unknown", FeatureHashcash}))
+	require.Empty(t, CommonFeatures(SupportedFeatures, nil))
+}
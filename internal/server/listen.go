@@ -0,0 +1,69 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// unixAddrPrefix marks the unix socket listen addresses.
+const unixAddrPrefix = "unix:"
+
+// isUnixClient reports whether the client address is the one of the unix socket clients.
+func isUnixClient(clientAddr string) bool {
+	return strings.HasPrefix(clientAddr, unixAddrPrefix)
+}
+
+// listen listens on the tcp address or the unix socket of the address with unixAddrPrefix.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+	if !ok {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("listen for tcp on %v error: %w", addr, err)
+		}
+		return listener, nil
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on unix socket %v error: %w", path, err)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket left by the server which didn't close its listener,
+// the socket accepting connections is kept.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat unix socket %v error: %w", path, err)
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("unix socket path %v is taken by a file", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("unix socket %v is in use", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("check unix socket %v error: %w", path, err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("remove stale unix socket %v error: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+	mocks "github.com/nikvakhrameev/pow_tcp_server/mocks/internal_/server"
+)
+
+func makeListenServer(t *testing.T, cfg Config) *Server {
+	mockDdosProtector := mocks.NewDdosProtector(t)
+	mockDdosProtector.On("GenerateChallenge", mock.Anything).
+		Return(pow.Challenge{Data: "test_data", Difficulty: 10}, nil).Maybe()
+
+	cfg.ProtocolProbeTimeout = 100 * time.Millisecond
+	cfg.HandleConnectionTimeout = 10 * time.Second
+	return NewServer(
+		cfg,
+		mockDdosProtector,
+		mocks.NewWisdomQuotesGetter(t),
+		slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+	)
+}
+
+// requireChallenge dials the server and reads the challenge sent after the hello.
+func requireChallenge(t *testing.T, network, addr string) {
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+
+	codec := NewJSONCodec(conn, conn, maxSolutionReadBytes)
+	require.NoError(t, codec.Encode(ClientHello{Versions: []int{ProtocolVersion2}}))
+
+	var hello ServerHello
+	require.NoError(t, codec.Decode(&hello))
+	var pc PowChallenge
+	require.NoError(t, codec.Decode(&pc))
+	require.Equal(t, "test_data", pc.Data)
+}
+
+func TestServer_RunListenAddrs(t *testing.T) {
+	dir := t.TempDir()
+	firstSocket, secondSocket := filepath.Join(dir, "first.sock"), filepath.Join(dir, "second.sock")
+
+	// The socket of the server which didn't close its listener.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: firstSocket, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	srv := makeListenServer(t, Config{ListenAddrs: []string{unixAddrPrefix + firstSocket, unixAddrPrefix + secondSocket}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErrChan := make(chan error, 1)
+	go func() {
+		runErrChan <- srv.Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(secondSocket)
+		return err == nil
+	}, time.Second, time.Millisecond)
+
+	requireChallenge(t, "unix", firstSocket)
+	requireChallenge(t, "unix", secondSocket)
+
+	cancel()
+	require.ErrorIs(t, <-runErrChan, context.Canceled)
+
+	require.NoFileExists(t, firstSocket)
+	require.NoFileExists(t, secondSocket)
+}
+
+func TestServer_RunListenAddrsInUse(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "pow.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	srv := makeListenServer(t, Config{ListenAddrs: []string{unixAddrPrefix + socket}})
+	require.ErrorContains(t, srv.Run(context.Background()), "is in use")
+
+	file := filepath.Join(t.TempDir(), "pow.sock")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+
+	srv = makeListenServer(t, Config{ListenAddrs: []string{unixAddrPrefix + file}})
+	require.ErrorContains(t, srv.Run(context.Background()), "is taken by a file")
+}
+
+func TestServer_ServeListeners(t *testing.T) {
+	listener4, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listeners := []net.Listener{listener4}
+	if listener6, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		listeners = append(listeners, listener6)
+	} else {
+		t.Log("ipv6 isn't supported:", err)
+	}
+
+	srv := makeListenServer(t, Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErrChan := make(chan error, 1)
+	go func() {
+		serveErrChan <- srv.Serve(ctx, listeners...)
+	}()
+
+	for _, listener := range listeners {
+		requireChallenge(t, "tcp", listener.Addr().String())
+	}
+
+	cancel()
+	require.ErrorIs(t, <-serveErrChan, context.Canceled)
+
+	for _, listener := range listeners {
+		_, err := net.DialTimeout("tcp", listener.Addr().String(), time.Second)
+		require.Error(t, err)
+	}
+}
+
+var errTestAccept = errors.New("test accept error")
+
+type failingListener struct {
+	net.Listener
+	accepted chan struct{}
+}
+
+func (l failingListener) Accept() (net.Conn, error) {
+	<-l.accepted
+	return nil, errTestAccept
+}
+
+func TestServer_ServeListenerError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	failing := failingListener{Listener: listener, accepted: make(chan struct{})}
+
+	other, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := makeListenServer(t, Config{})
+
+	serveErrChan := make(chan error, 1)
+	go func() {
+		serveErrChan <- srv.Serve(context.Background(), failing, other)
+	}()
+
+	requireChallenge(t, "tcp", other.Addr().String())
+
+	close(failing.accepted)
+	require.ErrorIs(t, <-serveErrChan, errTestAccept)
+
+	// The other listeners are closed on the failure.
+	_, err = net.DialTimeout("tcp", other.Addr().String(), time.Second)
+	require.Error(t, err)
+}
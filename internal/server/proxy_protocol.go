@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidProxyHeader is returned for the malformed PROXY protocol headers.
+var ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// proxyV1MaxLength is the longest v1 header including CRLF.
+	proxyV1MaxLength = 107
+	proxyV2HeaderLen = 16
+
+	proxyV2CommandLocal = 0x0
+	proxyV2CommandProxy = 0x1
+	proxyV2FamilyInet   = 0x1
+	proxyV2FamilyInet6  = 0x2
+)
+
+// ReadProxyHeader reads the PROXY protocol v1 or v2 header and returns the source address
+// from it. The address is nil for the headers without one, i.e. the v1 UNKNOWN ones and the
+// v2 LOCAL or non-IP ones, the connection is the proxy own one then.
+func ReadProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("read proxy protocol header error: %w", err)
+	}
+	switch first[0] {
+	case proxyV2Signature[0]:
+		return readProxyHeaderV2(r)
+	case proxyV1Prefix[0]:
+		return readProxyHeaderV1(r)
+	default:
+		return nil, fmt.Errorf("%w: no header", ErrInvalidProxyHeader)
+	}
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == proxyV1MaxLength {
+			return nil, fmt.Errorf("%w: v1 header is too long", ErrInvalidProxyHeader)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read proxy protocol header error: %w", err)
+		}
+		line = append(line, b)
+	}
+
+	if !bytes.HasPrefix(line, proxyV1Prefix) {
+		return nil, fmt.Errorf("%w: no v1 prefix", ErrInvalidProxyHeader)
+	}
+	fields := strings.Split(string(line[len(proxyV1Prefix):len(line)-2]), " ")
+	if fields[0] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return nil, fmt.Errorf("%w: v1 header %q", ErrInvalidProxyHeader, line)
+	}
+
+	src, err := netip.ParseAddr(fields[1])
+	if err != nil || src.Is4() != (fields[0] == "TCP4") {
+		return nil, fmt.Errorf("%w: v1 source address %q", ErrInvalidProxyHeader, fields[1])
+	}
+	if _, err := netip.ParseAddr(fields[2]); err != nil {
+		return nil, fmt.Errorf("%w: v1 destination address %q", ErrInvalidProxyHeader, fields[2])
+	}
+	port, err := strconv.ParseUint(fields[3], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: v1 source port %q", ErrInvalidProxyHeader, fields[3])
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, uint16(port))), nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("read proxy protocol header error: %w", err)
+	}
+	if !bytes.Equal(header[:len(proxyV2Signature)], proxyV2Signature) {
+		return nil, fmt.Errorf("%w: no v2 signature", ErrInvalidProxyHeader)
+	}
+
+	versionCommand, family := header[12], header[13]
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("%w: v2 version %v", ErrInvalidProxyHeader, versionCommand>>4)
+	}
+	command := versionCommand & 0xf
+	if command != proxyV2CommandLocal && command != proxyV2CommandProxy {
+		return nil, fmt.Errorf("%w: v2 command %v", ErrInvalidProxyHeader, command)
+	}
+
+	// The addresses are followed by the TLVs, they're skipped.
+	length := int(binary.BigEndian.Uint16(header[14:]))
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("read proxy protocol header error: %w", err)
+	}
+
+	if command == proxyV2CommandLocal {
+		return nil, nil
+	}
+
+	var (
+		src  netip.Addr
+		port uint16
+	)
+	switch family >> 4 {
+	case proxyV2FamilyInet:
+		if length < 12 {
+			return nil, fmt.Errorf("%w: v2 inet addresses length %v", ErrInvalidProxyHeader, length)
+		}
+		src, port = netip.AddrFrom4([4]byte(body[:4])), binary.BigEndian.Uint16(body[8:])
+	case proxyV2FamilyInet6:
+		if length < 36 {
+			return nil, fmt.Errorf("%w: v2 inet6 addresses length %v", ErrInvalidProxyHeader, length)
+		}
+		src, port = netip.AddrFrom16([16]byte(body[:16])), binary.BigEndian.Uint16(body[32:])
+	default:
+		return nil, nil
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, port)), nil
+}
+
+// ParseTrustedProxies parses the IPs and CIDRs of the trusted proxies.
+func ParseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("parse proxy address %q error: %w", proxy, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy prefix %q error: %w", proxy, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// proxyTrusted reports whether the connection comes from the trusted proxy.
+func (s *Server) proxyTrusted(conn net.Conn) bool {
+	if len(s.trustedProxies) == 0 {
+		return false
+	}
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// readProxyHeader reads the PROXY protocol header within the idle timeout and returns the
+// connection with the client address from it.
+func (s *Server) readProxyHeader(conn net.Conn) (net.Conn, error) {
+	deadline := phaseDeadline(phaseDeadline(time.Time{}, s.cfg.HandleConnectionTimeout), s.cfg.IdleTimeout)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("set proxy header deadline error: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	remoteAddr, err := ReadProxyHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("reset proxy header deadline error: %w", err)
+	}
+
+	if remoteAddr == nil {
+		remoteAddr = conn.RemoteAddr()
+	}
+	return &proxyConn{Conn: conn, r: r, remoteAddr: remoteAddr}, nil
+}
+
+// proxyConn is the connection of the trusted proxy with the client address from the PROXY
+// protocol header, the data buffered with the header is read first.
+type proxyConn struct {
+	net.Conn
+	r          *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// CloseWrite shuts down the write side of the proxy connection.
+func (c *proxyConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
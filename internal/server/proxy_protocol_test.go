@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+	mocks "github.com/nikvakhrameev/pow_tcp_server/mocks/internal_/server"
+)
+
+func proxyV2Header(command, family byte, addrs []byte) []byte {
+	header := append(bytes.Clone(proxyV2Signature), 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	inetAddrs := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0x1f, 0x90, 0x1f, 0x95}
+	inet6Addrs := append(netip.MustParseAddr("2001:db8::7").AsSlice(), netip.MustParseAddr("2001:db8::1").AsSlice()...)
+	inet6Addrs = append(inet6Addrs, 0x1f, 0x90, 0x1f, 0x95)
+
+	testCases := []struct {
+		Name     string
+		Header   []byte
+		Expected string
+		Err      bool
+	}{
+		{Name: "v1_tcp4", Header: []byte("PROXY TCP4 203.0.113.7 10.0.0.1 8080 8085\r\n"), Expected: "203.0.113.7:8080"},
+		{Name: "v1_tcp6", Header: []byte("PROXY TCP6 2001:db8::7 2001:db8::1 8080 8085\r\n"), Expected: "[2001:db8::7]:8080"},
+		{Name: "v1_unknown", Header: []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")},
+		{Name: "v1_family_mismatch", Header: []byte("PROXY TCP4 2001:db8::7 2001:db8::1 8080 8085\r\n"), Err: true},
+		{Name: "v1_invalid_port", Header: []byte("PROXY TCP4 203.0.113.7 10.0.0.1 80800 8085\r\n"), Err: true},
+		{Name: "v1_too_long", Header: append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), 200)...), Err: true},
+		{Name: "v1_no_crlf", Header: []byte("PROXY TCP4 203.0.113.7 10.0.0.1 8080 8085\n"), Err: true},
+		{Name: "v2_inet", Header: proxyV2Header(proxyV2CommandProxy, 0x11, inetAddrs), Expected: "203.0.113.7:8080"},
+		{Name: "v2_inet6", Header: proxyV2Header(proxyV2CommandProxy, 0x21, inet6Addrs), Expected: "[2001:db8::7]:8080"},
+		{Name: "v2_tlvs", Header: proxyV2Header(proxyV2CommandProxy, 0x11, append(inetAddrs, 0x04, 0x00, 0x01, 0xff)), Expected: "203.0.113.7:8080"},
+		{Name: "v2_local", Header: proxyV2Header(proxyV2CommandLocal, 0x00, nil)},
+		{Name: "v2_unix", Header: proxyV2Header(proxyV2CommandProxy, 0x31, make([]byte, 216))},
+		{Name: "v2_short_addrs", Header: proxyV2Header(proxyV2CommandProxy, 0x11, inetAddrs[:8]), Err: true},
+		{Name: "v2_invalid_command", Header: proxyV2Header(0x2, 0x11, inetAddrs), Err: true},
+		{Name: "v2_truncated", Header: proxyV2Header(proxyV2CommandProxy, 0x11, inetAddrs)[:20], Err: true},
+		{Name: "no_header", Header: []byte(`{"Versions":[2]}`), Err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(tc.Header), bytes.NewReader([]byte("data"))))
+
+			addr, err := ReadProxyHeader(r)
+			if tc.Err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			if tc.Expected == "" {
+				require.Nil(t, addr)
+			} else {
+				require.Equal(t, tc.Expected, addr.String())
+			}
+
+			rest, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, "data", string(rest))
+		})
+	}
+}
+
+func FuzzReadProxyHeader(f *testing.F) {
+	f.Add([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 8080 8085\r\n"))
+	f.Add(proxyV2Header(proxyV2CommandProxy, 0x11, []byte{203, 0, 113, 7, 10, 0, 0, 1, 0x1f, 0x90, 0x1f, 0x95}))
+
+	f.Fuzz(func(t *testing.T, header []byte) {
+		addr, err := ReadProxyHeader(bufio.NewReader(bytes.NewReader(header)))
+		if err != nil || addr == nil {
+			return
+		}
+		_, err = netip.ParseAddrPort(addr.String())
+		require.NoError(t, err)
+	})
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := ParseTrustedProxies([]string{"10.0.0.1", " 192.168.0.0/16", "2001:db8::1/64", ""})
+	require.NoError(t, err)
+	require.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.1/32"),
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("2001:db8::/64"),
+	}, prefixes)
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	require.Error(t, err)
+	_, err = ParseTrustedProxies([]string{"proxy"})
+	require.Error(t, err)
+}
+
+func TestServer_ServeProxyProtocol(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		TrustedProxies []string
+		Header         string
+		Client         pow.ClientInfo
+		Closed         bool
+	}{
+		{
+			Name:           "trusted_proxy",
+			TrustedProxies: []string{"127.0.0.0/8"},
+			Header:         "PROXY TCP4 203.0.113.7 127.0.0.1 8080 8085\r\n",
+			Client:         pow.ClientInfo{Addr: "203.0.113.7"},
+		},
+		{
+			Name:           "trusted_proxy_health_check",
+			TrustedProxies: []string{"127.0.0.1"},
+			Header:         string(proxyV2Header(proxyV2CommandLocal, 0x00, nil)),
+			Client:         pow.ClientInfo{Addr: "127.0.0.1"},
+		},
+		{
+			Name:           "trusted_proxy_without_header",
+			TrustedProxies: []string{"127.0.0.1"},
+			Closed:         true,
+		},
+		{
+			Name:           "untrusted_proxy",
+			TrustedProxies: []string{"10.0.0.0/8"},
+			Client:         pow.ClientInfo{Addr: "127.0.0.1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mockDdosProtector := mocks.NewDdosProtector(t)
+			srv := NewServer(
+				Config{
+					ProxyProtocolTrustedProxies: tc.TrustedProxies,
+					ProtocolProbeTimeout:        100 * time.Millisecond,
+					HandleConnectionTimeout:     10 * time.Second,
+				},
+				mockDdosProtector,
+				mocks.NewWisdomQuotesGetter(t),
+				slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+			)
+
+			challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+			if !tc.Closed {
+				mockDdosProtector.On("GenerateChallenge", tc.Client).Return(challenge, nil).Once()
+			}
+
+			addr, stop := startServer(t, srv)
+			defer stop()
+
+			conn, err := net.DialTimeout("tcp", addr, time.Second)
+			require.NoError(t, err)
+			defer conn.Close()
+			require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+
+			codec := NewJSONCodec(conn, conn, maxSolutionReadBytes)
+			// The header and the hello are sent at once, the hello is buffered with the header.
+			hello, err := json.Marshal(ClientHello{Versions: []int{ProtocolVersion2}})
+			require.NoError(t, err)
+			_, err = conn.Write(append([]byte(tc.Header), hello...))
+			require.NoError(t, err)
+
+			var serverHello ServerHello
+			if tc.Closed {
+				require.ErrorIs(t, codec.Decode(&serverHello), io.EOF)
+				return
+			}
+			require.NoError(t, codec.Decode(&serverHello))
+			var pc PowChallenge
+			require.NoError(t, codec.Decode(&pc))
+			require.Equal(t, PowChallenge(challenge), pc)
+		})
+	}
+}
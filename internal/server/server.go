@@ -1,21 +1,73 @@
 package server
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
 )
 
 type Server struct {
-	logger        *slog.Logger
-	cfg           Config
-	ddosProtector DdosProtector
-	wisdomQuotes  WisdomQuotesGetter
+	logger         *slog.Logger
+	cfg            Config
+	ddosProtector  DdosProtector
+	wisdomQuotes   WisdomQuotesGetter
+	loadReporter   LoadReporter
+	clientReporter ClientReporter
+	accessTokens   AccessTokenIssuer
+	limiter        *connLimiter
+	tlsConfig      *tls.Config
+	trustedProxies []netip.Prefix
+	// rejecting is the number of connections being rejected with the error message.
+	rejecting           atomic.Int64
+	readingProxyHeaders atomic.Int64
+
+	// conns are the in-flight connections, the shutdown waits for connsWG within the grace
+	// period and closes the rest ones.
+	connsMu sync.Mutex
+	conns   map[net.Conn]struct{}
+	connsWG sync.WaitGroup
+	closing atomic.Bool
+
+	// listeners are the ones the server accepts connections on, they may be passed to the new process.
+	listenersMu sync.Mutex
+	listeners   []net.Listener
+
+	upstreamStats upstreamCounters
+}
+
+type Option func(s *Server)
+
+func WithLoadReporter(reporter LoadReporter) Option {
+	return func(s *Server) {
+		s.loadReporter = reporter
+	}
+}
+
+func WithClientReporter(reporter ClientReporter) Option {
+	return func(s *Server) {
+		s.clientReporter = reporter
+	}
+}
+
+// WithAccessTokens makes the server issue the access tokens to the clients supporting them.
+func WithAccessTokens(issuer AccessTokenIssuer) Option {
+	return func(s *Server) {
+		s.accessTokens = issuer
+	}
 }
 
 func NewServer(
@@ -23,101 +75,872 @@ func NewServer(
 	protector DdosProtector,
 	wisdomQuotes WisdomQuotesGetter,
 	logger slog.Handler,
+	opts ...Option,
 ) *Server {
-	return &Server{
-		cfg:           cfg,
-		ddosProtector: protector,
-		wisdomQuotes:  wisdomQuotes,
-		logger:        slog.New(logger.WithGroup("server")),
+	s := &Server{
+		cfg:            cfg,
+		ddosProtector:  protector,
+		wisdomQuotes:   wisdomQuotes,
+		loadReporter:   noopLoadReporter{},
+		clientReporter: noopClientReporter{},
+		limiter:        newConnLimiter(cfg),
+		conns:          make(map[net.Conn]struct{}),
+		logger:         slog.New(logger.WithGroup("server")),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
+// Run serves the listeners inherited with the socket activation protocol, it listens on the
+// configured addresses when there are none.
 func (s *Server) Run(ctx context.Context) error {
-	listener, err := net.Listen("tcp", s.cfg.Port)
+	inherited, err := InheritedListeners()
 	if err != nil {
-		return fmt.Errorf("listen for tcp on %v error: %w", s.cfg.Port, err)
+		return fmt.Errorf("inherit listeners error: %w", err)
+	}
+	if len(inherited) > 0 {
+		for _, listener := range inherited {
+			s.logger.Info("listening on inherited listener", "addr", listener.Addr())
+		}
+		return s.Serve(ctx, inherited...)
 	}
 
-	go func() {
-		<-ctx.Done()
-		if err := listener.Close(); err != nil {
-			s.logger.Error("close listener error", "err", err)
+	addrs := s.cfg.ListenAddrs
+	if len(addrs) == 0 {
+		addrs = []string{s.cfg.Port}
+	}
+
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		listener, err := listen(addr)
+		if err != nil {
+			closeListeners(listeners)
+			return err
 		}
-	}()
+		s.logger.Info("listening", "addr", addr)
+		listeners = append(listeners, listener)
+	}
+
+	return s.Serve(ctx, listeners...)
+}
+
+// Serve handles the connections accepted on the listeners until ctx is done or any listener
+// fails, the listeners are closed then. It returns once the in-flight connections are drained.
+func (s *Server) Serve(ctx context.Context, listeners ...net.Listener) error {
+	if err := s.configureListener(); err != nil {
+		closeListeners(listeners)
+		return err
+	}
 
+	defer s.drain()
+
+	s.listenersMu.Lock()
+	s.listeners = listeners
+	s.listenersMu.Unlock()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var acceptWG sync.WaitGroup
+	for _, listener := range listeners {
+		acceptWG.Add(1)
+		go func(listener net.Listener) {
+			defer acceptWG.Done()
+			cancel(s.accept(ctx, listener))
+		}(listener)
+	}
+
+	<-ctx.Done()
+	closeListeners(listeners)
+	acceptWG.Wait()
+
+	return context.Cause(ctx)
+}
+
+// accept handles the connections accepted on the listener until it's closed.
+func (s *Server) accept(ctx context.Context, listener net.Listener) error {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return context.Canceled
 			}
-			return fmt.Errorf("accept new connection error: %w", err)
+			return fmt.Errorf("accept new connection on %v error: %w", listener.Addr(), err)
 		}
 
-		go func() {
-			if err := s.handleConnection(conn); err != nil {
-				s.logger.Error("handle connection error", "err", err)
+		s.admit(ctx, conn)
+	}
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		// The listeners closed by the failed accept loop are closed again on return.
+		_ = listener.Close()
+	}
+}
+
+// configureListener loads the TLS config and the trusted proxies applied to the accepted connections.
+func (s *Server) configureListener() error {
+	var err error
+	if s.cfg.TLS.Enabled() {
+		if s.tlsConfig, err = NewTLSConfig(s.cfg.TLS); err != nil {
+			return fmt.Errorf("create tls config error: %w", err)
+		}
+	}
+	if s.trustedProxies, err = ParseTrustedProxies(s.cfg.ProxyProtocolTrustedProxies); err != nil {
+		return fmt.Errorf("parse trusted proxies error: %w", err)
+	}
+	return nil
+}
+
+// maxRejectingConnections bounds the connections being rejected with the error message,
+// the ones over it are closed right away.
+const maxRejectingConnections = 1024
+
+// maxReadingProxyHeaders bounds the connections of the trusted proxies waiting for the PROXY
+// protocol header, the ones over it are closed right away.
+const maxReadingProxyHeaders = 1024
+
+// rejectTimeout bounds the rejection of the connection over the limits.
+const rejectTimeout = time.Second
+
+// admit handles the connection within the connection limits. The connections of the trusted
+// proxies are limited by the client address from the PROXY protocol header, it's read in the
+// connection goroutine not to block the accept loop.
+func (s *Server) admit(ctx context.Context, conn net.Conn) {
+	if !s.proxyTrusted(conn) {
+		if handle := s.limit(ctx, s.secure(conn)); handle != nil {
+			s.track(conn, handle)
+		}
+		return
+	}
+
+	if s.readingProxyHeaders.Add(1) > maxReadingProxyHeaders {
+		s.readingProxyHeaders.Add(-1)
+		s.logger.Warn("too many pending proxy protocol headers, close connection", "proxy_addr", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	s.track(conn, func() {
+		proxied, err := s.readProxyHeader(conn)
+		s.readingProxyHeaders.Add(-1)
+		if err != nil {
+			s.logger.Warn("read proxy protocol header error, close connection", "proxy_addr", conn.RemoteAddr(), "err", err)
+			conn.Close()
+			return
+		}
+		if handle := s.limit(ctx, s.secure(proxied)); handle != nil {
+			handle()
+		}
+	})
+}
+
+// limit returns the handler of the connection within the connection limits. The connections
+// over the total limit wait in the queue when it's enabled and not full, the rest ones are
+// rejected. It's nil for the connections closed right away.
+func (s *Server) limit(ctx context.Context, conn net.Conn) func() {
+	client := clientInfo(conn)
+
+	code := s.limiter.acquire(client.Addr)
+	if code == "" {
+		return func() { s.serve(conn, client) }
+	}
+
+	if code == CodeServerOverloaded && s.cfg.ConnectionQueueTimeout > 0 && s.limiter.enqueue() {
+		return func() {
+			if code := s.limiter.wait(ctx, client.Addr, s.cfg.ConnectionQueueTimeout); code != "" {
+				if handle := s.rejection(conn, client, code); handle != nil {
+					handle()
+				}
+				return
 			}
+			s.serve(conn, client)
+		}
+	}
+
+	return s.rejection(conn, client, code)
+}
+
+// rejection returns the handler rejecting the connection with the error message, it's nil
+// for the connections over maxRejectingConnections closed right away.
+func (s *Server) rejection(conn net.Conn, client pow.ClientInfo, code ErrorCode) func() {
+	if s.rejecting.Add(1) > maxRejectingConnections {
+		s.rejecting.Add(-1)
+		s.logger.Warn("connection limit exceeded, close connection", "client_addr", client.Addr, "code", code)
+		conn.Close()
+		return nil
+	}
+	return func() {
+		defer s.rejecting.Add(-1)
+		s.reject(conn, client, code)
+	}
+}
+
+// secure wraps the connection with TLS when it's enabled, the handshake is performed by the handler.
+func (s *Server) secure(conn net.Conn) net.Conn {
+	if s.tlsConfig == nil {
+		return conn
+	}
+	return tls.Server(conn, s.tlsConfig)
+}
+
+// track handles the connection in the goroutine the shutdown waits for.
+func (s *Server) track(conn net.Conn, handle func()) {
+	s.connsMu.Lock()
+	s.conns[conn] = struct{}{}
+	s.connsMu.Unlock()
+
+	s.connsWG.Add(1)
+	go func() {
+		defer s.connsWG.Done()
+		defer func() {
+			s.connsMu.Lock()
+			delete(s.conns, conn)
+			s.connsMu.Unlock()
 		}()
+
+		handle()
+	}()
+}
+
+// drain waits for the in-flight connections to finish within the shutdown grace period
+// and closes the rest ones. The sessions are expired on the next quote request.
+func (s *Server) drain() {
+	s.closing.Store(true)
+
+	drained := make(chan struct{})
+	go func() {
+		s.connsWG.Wait()
+		close(drained)
+	}()
+
+	s.connsMu.Lock()
+	s.logger.Info("draining connections", "active", len(s.conns), "grace_period", s.cfg.ShutdownGracePeriod)
+	s.connsMu.Unlock()
+
+	timer := time.NewTimer(s.cfg.ShutdownGracePeriod)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+		return
+	case <-timer.C:
 	}
+
+	s.connsMu.Lock()
+	s.logger.Warn("shutdown grace period exceeded, close connections", "active", len(s.conns))
+	for conn := range s.conns {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.logger.Warn("close connection error", "err", err)
+		}
+	}
+	s.connsMu.Unlock()
+
+	<-drained
+}
+
+// serve handles the admitted connection and releases its slot.
+func (s *Server) serve(conn net.Conn, client pow.ClientInfo) {
+	defer s.limiter.release(client.Addr)
+
+	s.loadReporter.ConnectionAccepted()
+	defer s.loadReporter.ConnectionClosed()
+
+	if err := s.handleConnection(conn); err != nil {
+		s.logger.Error("handle connection error", "err", err)
+	}
+}
+
+// reject sends the error message to the client expecting it and closes the connection.
+func (s *Server) reject(conn net.Conn, client pow.ClientInfo, code ErrorCode) {
+	defer conn.Close()
+
+	s.logger.Warn("connection limit exceeded, reject connection", "client_addr", client.Addr, "code", code)
+
+	deadline := time.Now().Add(rejectTimeout)
+	if err := conn.SetDeadline(deadline); err != nil {
+		s.logger.Warn("set connection deadline error", "err", err)
+		return
+	}
+
+	// The client hello is read, otherwise closing the connection with the unread data
+	// may reset it before the client reads the error message. It's awaited even when the server
+	// doesn't probe for it, the legacy clients sending nothing just get the connection closed.
+	proto, _, err := s.readHello(conn, deadline, rejectTimeout)
+	if err != nil {
+		return
+	}
+	s.sendError(proto, ErrorMessage{Code: code, Message: errorTexts[code]})
 }
 
 func (s *Server) handleConnection(conn net.Conn) error {
-	s.logger.Info("got new connection")
+	client := clientInfo(conn)
+
+	s.logger.Info("got new connection", "client_addr", client.Addr)
+	s.reporter(client).ConnectionOpened(client.Addr)
 
+	var deadline time.Time
 	if s.cfg.HandleConnectionTimeout != 0 {
-		if err := conn.SetDeadline(time.Now().Add(s.cfg.HandleConnectionTimeout)); err != nil {
+		deadline = time.Now().Add(s.cfg.HandleConnectionTimeout)
+		if err := conn.SetDeadline(deadline); err != nil {
 			return fmt.Errorf("set connection deadline error: %w", err)
 		}
 	}
 	defer conn.Close()
 
-	ok, err := s.verifyConnection(conn)
+	trusted, err := s.tlsHandshake(conn, deadline)
 	if err != nil {
-		return fmt.Errorf("verify connection error: %w", err)
+		s.reportFailure(client, err)
+		return err
 	}
-	if !ok {
-		s.logger.Warn("connection wasn't verified, reject connection")
-		return nil
+	if trusted {
+		s.logger.Info("client presented trusted certificate", "client_addr", client.Addr)
+		client.Trusted = true
+	}
+
+	timeouts := newTimeoutConn(conn, deadline, s.cfg.WriteTimeout, s.cfg.IdleTimeout)
+	conn = timeouts
+
+	proto, err := s.negotiateProtocol(conn, client, deadline)
+	if err != nil {
+		s.reportFailure(client, err)
+		s.sendError(proto, errorMessage(err))
+		return fmt.Errorf("negotiate protocol error: %w", err)
+	}
+
+	wow := WordOfWisdom{}
+	if proto.tokenAccepted {
+		s.logger.Info("access token accepted, skip challenge", "client_addr", client.Addr)
+	} else {
+		code, err := s.verifyConnection(conn, proto, client, deadline)
+		if err != nil {
+			s.reportFailure(client, err)
+			s.sendError(proto, errorMessage(err))
+			return fmt.Errorf("verify connection error: %w", err)
+		}
+		if code != "" {
+			s.reportFailure(client, nil)
+			s.logger.Warn("connection wasn't verified, reject connection", "code", code)
+			s.sendError(proto, ErrorMessage{Code: code, Message: errorTexts[code]})
+			return nil
+		}
+		s.reporter(client).VerificationSucceeded(client.Addr)
+
+		if slices.Contains(proto.features, FeatureAccessToken) {
+			wow.Token, wow.TokenExpiresAt = s.issueAccessToken(client)
+		}
 	}
 
-	quoteOfWisdom := s.wisdomQuotes.GetWisdomQuote()
-	if err := json.NewEncoder(conn).Encode(WordOfWisdom{Text: quoteOfWisdom}); err != nil {
+	if s.upstreamEnabled() {
+		return s.spliceUpstream(timeouts, proto, client, wow)
+	}
+
+	wow.Text = s.wisdomQuotes.GetWisdomQuote()
+	if err := proto.codec.Encode(wow); err != nil {
 		return fmt.Errorf("write word of wisdom to connection error: %w", err)
 	}
 
+	if slices.Contains(proto.features, FeatureSession) {
+		return s.serveSession(conn, proto, deadline)
+	}
+
 	return nil
 }
 
-const maxNonceReadBytes = 32
+// issueAccessToken returns the access token for the verified client, the client solves the
+// challenges again when it can't be issued.
+func (s *Server) issueAccessToken(client pow.ClientInfo) (string, int64) {
+	token, expiresAt, err := s.accessTokens.IssueToken(client)
+	if err != nil {
+		s.logger.Error("issue access token error", "client_addr", client.Addr, "err", err)
+		return "", 0
+	}
+	return token, expiresAt.Unix()
+}
+
+// features returns the protocol features enabled on the server.
+func (s *Server) features() []string {
+	return slices.DeleteFunc(slices.Clone(SupportedFeatures), func(feature string) bool {
+		switch feature {
+		case FeatureSession:
+			return !s.sessionsEnabled() || s.upstreamEnabled()
+		case FeatureUpstream:
+			return !s.upstreamEnabled()
+		case FeatureAccessToken:
+			return s.accessTokens == nil
+		default:
+			return false
+		}
+	})
+}
+
+func (s *Server) sessionsEnabled() bool {
+	return s.cfg.SessionMaxQuotes > 1
+}
+
+// serveSession answers the quote requests of the verified client until the session budget
+// is spent or the server shuts down. The first quote is already sent on verification. The idle
+// sessions are expired like the ones exceeding the session duration.
+func (s *Server) serveSession(conn net.Conn, proto connProtocol, deadline time.Time) error {
+	sessionDeadline := phaseDeadline(deadline, s.cfg.SessionDuration)
+
+	sessionExpired := ErrorMessage{Code: CodeSessionExpired, Message: errorTexts[CodeSessionExpired]}
+
+	for quotes := 1; ; quotes++ {
+		if err := conn.SetReadDeadline(phaseDeadline(sessionDeadline, s.cfg.IdleTimeout)); err != nil {
+			return fmt.Errorf("set session deadline error: %w", err)
+		}
+
+		var req QuoteRequest
+		if err := proto.codec.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) && (deadline.IsZero() || time.Now().Before(deadline)) {
+				s.sendError(proto, sessionExpired)
+				return nil
+			}
+			return fmt.Errorf("decode quote request error: %w", err)
+		}
+
+		if quotes >= s.cfg.SessionMaxQuotes || s.closing.Load() {
+			s.sendError(proto, sessionExpired)
+			return nil
+		}
+
+		if err := proto.codec.Encode(WordOfWisdom{Text: s.wisdomQuotes.GetWisdomQuote()}); err != nil {
+			return fmt.Errorf("write word of wisdom to connection error: %w", err)
+		}
+	}
+}
+
+// reporter returns the reporter of the client outcomes, the unix socket clients are reported
+// only when the per client limits apply to them.
+func (s *Server) reporter(client pow.ClientInfo) ClientReporter {
+	if isUnixClient(client.Addr) && !s.cfg.UnixClientLimits {
+		return noopClientReporter{}
+	}
+	return s.clientReporter
+}
+
+// reportFailure reports the failed verification, the connections failed with the
+// deadline exceeded are reported as timed out.
+func (s *Server) reportFailure(client pow.ClientInfo, err error) {
+	s.loadReporter.VerificationFailed()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		s.reporter(client).ConnectionTimedOut(client.Addr)
+	} else {
+		s.reporter(client).VerificationFailed(client.Addr)
+	}
+}
+
+// sendError sends the error message to the clients expecting it, the failure to send it
+// is only logged as the connection is closed anyway.
+func (s *Server) sendError(proto connProtocol, errMsg ErrorMessage) {
+	if proto.codec == nil || !proto.errorMessages() {
+		return
+	}
+	if err := proto.codec.Encode(errMsg); err != nil {
+		s.logger.Warn("write error message to connection error", "err", err)
+	}
+}
+
+var errorTexts = map[ErrorCode]string{
+	CodeInvalidSolution:     "the solution doesn't solve the challenge",
+	CodeExpiredChallenge:    "the challenge is expired",
+	CodeRateLimited:         "too many connections from the client",
+	CodeServerOverloaded:    "the server is overloaded",
+	CodeInternalError:       "internal server error",
+	CodeSessionExpired:      "the session is expired, solve a new challenge",
+	CodeUpstreamUnavailable: "the upstream is unavailable",
+}
+
+// errorMessage returns the error message for the connection failed with err. Only the protocol
+// errors are detailed, the internal ones aren't exposed to the client.
+func errorMessage(err error) ErrorMessage {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	if errors.Is(err, ErrIncompatibleProtocol) || errors.Is(err, ErrFeatureNotAgreed) || errors.Is(err, ErrMalformedMessage) ||
+		errors.Is(err, ErrUnexpectedMessage) || errors.Is(err, ErrMessageTooLarge) ||
+		errors.Is(err, ErrUnsupportedFrameVersion) || errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return ErrorMessage{Code: CodeProtocolError, Message: err.Error()}
+	}
+	return ErrorMessage{Code: CodeInternalError, Message: errorTexts[CodeInternalError]}
+}
+
+// connProtocol is the protocol agreed with the client.
+type connProtocol struct {
+	codec Codec
+	// reader is the one the codec reads the connection with.
+	reader io.Reader
+	binary bool
+	// hello is set when the client sent the hello, even the one the server rejected.
+	hello    bool
+	version  int
+	features []string
+	// tokenAccepted is set when the client skips the challenge with the access token.
+	tokenAccepted bool
+	// challenge is the one sent before the late hello, solution is the one the legacy client
+	// sent in place of the late hello.
+	challenge *pow.Challenge
+	solution  *PowChallengeSolution
+}
+
+// errorMessages reports whether the client expects the error message before the connection
+// is closed. The legacy JSON clients predate them and expect the connection to be just closed.
+func (p connProtocol) errorMessages() bool {
+	return p.binary || p.hello
+}
 
-func (s *Server) verifyConnection(conn net.Conn) (bool, error) {
-	pow, err := s.ddosProtector.GenerateChallenge()
+// negotiateProtocol selects the binary codec when the client starts with the magic byte and
+// performs the hello exchange when the client sends the hello. The clients which send nothing
+// within the probe timeout speak the protocol version 1. The server which doesn't probe reads
+// the late hello instead. In the reverse proxy mode only the clients agreeing to the upstream
+// feature are served.
+func (s *Server) negotiateProtocol(conn net.Conn, client pow.ClientInfo, deadline time.Time) (connProtocol, error) {
+	var (
+		proto connProtocol
+		hello *ClientHello
+		err   error
+	)
+	if s.cfg.ProtocolProbeTimeout == 0 {
+		proto, hello, err = s.readLateHello(conn, client, deadline)
+	} else {
+		helloDeadline := phaseDeadline(deadline, s.cfg.IdleTimeout)
+		if err := conn.SetReadDeadline(helloDeadline); err != nil {
+			return proto, fmt.Errorf("set hello deadline error: %w", err)
+		}
+		proto, hello, err = s.readHello(conn, helloDeadline, s.cfg.ProtocolProbeTimeout)
+	}
 	if err != nil {
-		return false, fmt.Errorf("generate solution error: %w", err)
+		return proto, err
+	}
+	if hello == nil {
+		if s.upstreamEnabled() {
+			return proto, fmt.Errorf("%w: the upstream needs the hello", ErrIncompatibleProtocol)
+		}
+		return proto, nil
 	}
 
-	logger := s.logger.With("data", pow.Data, "difficulty", pow.Difficulty)
+	version, ok := NegotiateVersion(SupportedVersions, hello.Versions)
+	if !ok {
+		return proto, fmt.Errorf("%w: client versions %v, server versions %v",
+			ErrIncompatibleProtocol, hello.Versions, SupportedVersions)
+	}
 
-	logger.Info("pow challenge generated")
+	proto.version = version
+	proto.features = CommonFeatures(s.features(), hello.Features)
+	if s.upstreamEnabled() && !slices.Contains(proto.features, FeatureUpstream) {
+		return proto, fmt.Errorf("%w: client features %v lack %v", ErrIncompatibleProtocol, hello.Features, FeatureUpstream)
+	}
 
-	if err := json.NewEncoder(conn).Encode(PowChallenge(pow)); err != nil {
-		return false, fmt.Errorf("encode pow challenge error: %w", err)
+	if hello.Token != "" && slices.Contains(proto.features, FeatureAccessToken) {
+		if proto.tokenAccepted, err = s.checkAccessToken(hello.Token, client); err != nil {
+			return proto, err
+		}
 	}
 
-	var powSolution PowChallengeSolution
-	if err := json.NewDecoder(io.LimitReader(conn, maxNonceReadBytes)).Decode(&powSolution); err != nil {
-		return false, fmt.Errorf("decode pos challenge solution error: %w", err)
+	serverHello := ServerHello{Version: proto.version, Features: proto.features, TokenAccepted: proto.tokenAccepted}
+	if err := proto.codec.Encode(serverHello); err != nil {
+		return proto, fmt.Errorf("write server hello error: %w", err)
+	}
+
+	s.logger.Info("protocol negotiated", "binary", proto.binary, "version", proto.version, "features", proto.features)
+
+	return proto, nil
+}
+
+// checkAccessToken reports whether the client may skip the challenge, the rejected tokens
+// are only logged and the client solves the challenge as usual.
+func (s *Server) checkAccessToken(token string, client pow.ClientInfo) (bool, error) {
+	ok, err := s.accessTokens.CheckToken(token, client)
+	if err != nil {
+		if errors.Is(err, pow.ErrChallengeRejected) {
+			s.logger.Warn("access token rejected", "client_addr", client.Addr, "err", err)
+			return false, nil
+		}
+		return false, fmt.Errorf("check access token error: %w", err)
+	}
+	return ok, nil
+}
+
+// readHello selects the codec and reads the client hello, it's nil for the clients which
+// don't send anything within the probe timeout.
+func (s *Server) readHello(conn net.Conn, deadline time.Time, probeTimeout time.Duration) (connProtocol, *ClientHello, error) {
+	proto := connProtocol{version: ProtocolVersion1}
+
+	r := bufio.NewReader(conn)
+	sent, err := probe(conn, r, deadline, probeTimeout)
+	if err != nil {
+		return proto, nil, err
+	}
+
+	if sent {
+		if first, _ := r.Peek(1); first[0] == BinaryMagic {
+			_, _ = r.Discard(1)
+			proto.binary = true
+			if sent, err = probe(conn, r, deadline, probeTimeout); err != nil {
+				return proto, nil, err
+			}
+		}
+	}
+
+	proto.reader = r
+	if proto.binary {
+		proto.codec = NewBinaryCodec(r, conn, maxSolutionReadBytes)
+	} else {
+		proto.codec = NewJSONCodec(r, conn, maxSolutionReadBytes)
+	}
+
+	if !sent {
+		return proto, nil, nil
+	}
+
+	proto.hello = true
+
+	var hello ClientHello
+	if err := proto.codec.Decode(&hello); err != nil {
+		return proto, nil, fmt.Errorf("decode client hello error: %w", err)
+	}
+
+	return proto, &hello, nil
+}
+
+// readLateHello sends the challenge at once, so the legacy clients don't wait for the probe,
+// and reads the client hello in place of the solution. The binary clients send the magic byte
+// and the hello, they skip the JSON challenge. The challenge is sent again with the agreed codec
+// after the hello, the solution of the legacy client is checked without reading it again.
+func (s *Server) readLateHello(conn net.Conn, client pow.ClientInfo, deadline time.Time) (connProtocol, *ClientHello, error) {
+	r := bufio.NewReader(conn)
+	proto := connProtocol{version: ProtocolVersion1, reader: r, codec: NewJSONCodec(r, conn, maxSolutionReadBytes)}
+
+	challenge, err := s.sendChallenge(proto.codec, client)
+	if err != nil {
+		return proto, nil, err
+	}
+	proto.challenge = &challenge
+
+	if err := conn.SetReadDeadline(phaseDeadline(deadline, s.solutionTimeout(challenge))); err != nil {
+		return proto, nil, fmt.Errorf("set solution deadline error: %w", err)
+	}
+
+	first, err := r.Peek(1)
+	if err != nil {
+		return proto, nil, fmt.Errorf("read client message error: %w", err)
+	}
+
+	if first[0] == BinaryMagic {
+		_, _ = r.Discard(1)
+		proto.binary, proto.hello = true, true
+		proto.codec = NewBinaryCodec(r, conn, maxSolutionReadBytes)
+
+		var hello ClientHello
+		if err := proto.codec.Decode(&hello); err != nil {
+			return proto, nil, fmt.Errorf("decode client hello error: %w", err)
+		}
+		return proto, &hello, nil
+	}
+
+	var msg lateHelloMessage
+	if err := proto.codec.Decode(&msg); err != nil {
+		return proto, nil, fmt.Errorf("decode pos challenge solution error: %w", err)
+	}
+	// The hello always has the versions, the solution has none.
+	if msg.Versions == nil {
+		proto.solution = &msg.PowChallengeSolution
+		return proto, nil, nil
+	}
+
+	proto.hello = true
+	return proto, &msg.ClientHello, nil
+}
+
+// lateHelloMessage is the JSON message read after the challenge sent at once, it's either
+// the hello or the solution.
+type lateHelloMessage struct {
+	ClientHello
+	PowChallengeSolution
+}
+
+func (lateHelloMessage) messageType() MessageType { return MessageSolution }
+
+func (m lateHelloMessage) appendBinary(b []byte) []byte {
+	return m.PowChallengeSolution.appendBinary(b)
+}
+
+func (m *lateHelloMessage) unmarshalBinary(data []byte) error {
+	return m.PowChallengeSolution.unmarshalBinary(data)
+}
+
+// probe reports whether the client sends anything within the probe timeout.
+func probe(conn net.Conn, r *bufio.Reader, deadline time.Time, probeTimeout time.Duration) (bool, error) {
+	if r.Buffered() > 0 {
+		return true, nil
+	}
+
+	probeDeadline := time.Now().Add(probeTimeout)
+	if !deadline.IsZero() && deadline.Before(probeDeadline) {
+		probeDeadline = deadline
+	}
+	if err := conn.SetReadDeadline(probeDeadline); err != nil {
+		return false, fmt.Errorf("set probe deadline error: %w", err)
+	}
+
+	_, err := r.Peek(1)
+	if err != nil && (!errors.Is(err, os.ErrDeadlineExceeded) || probeDeadline.Equal(deadline)) {
+		return false, fmt.Errorf("probe protocol error: %w", err)
+	}
+
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return false, fmt.Errorf("restore connection deadline error: %w", err)
+	}
+
+	return err == nil, nil
+}
+
+const maxSolutionReadBytes = 1024
+
+// verifyConnection sends the challenge and checks the client solution. The code is empty
+// for the verified connections and tells why the connection is rejected otherwise. The
+// hashcash stamps and the redeemed challenges are accepted only when their features are agreed.
+func (s *Server) verifyConnection(conn net.Conn, proto connProtocol, client pow.ClientInfo, deadline time.Time) (ErrorCode, error) {
+	challenge, powSolution, err := s.readSolution(conn, proto, client, deadline)
+	if err != nil {
+		return "", err
+	}
+
+	logger := s.logger.With("client_addr", client.Addr, "data", challenge.Data, "difficulty", challenge.Difficulty)
+
+	if powSolution.Stamp != "" {
+		if !slices.Contains(proto.features, FeatureHashcash) {
+			return "", fmt.Errorf("%w: %v", ErrFeatureNotAgreed, FeatureHashcash)
+		}
+		logger = logger.With("stamp", powSolution.Stamp)
+		logger.Info("got hashcash stamp")
+
+		ok, err := s.ddosProtector.CheckStamp(challenge, powSolution.Stamp)
+		return s.checkResult(logger, ok, err)
+	}
+
+	if powSolution.Challenge != nil {
+		if !slices.Contains(proto.features, FeatureRedeem) {
+			return "", fmt.Errorf("%w: %v", ErrFeatureNotAgreed, FeatureRedeem)
+		}
+		if powSolution.Challenge.Signature == "" {
+			logger.Warn("redeemed challenge isn't signed")
+			return CodeInvalidSolution, nil
+		}
+		challenge = pow.Challenge(*powSolution.Challenge)
+		logger = logger.With("redeemed_data", challenge.Data)
 	}
 
 	logger = logger.With("solution_nonce", powSolution.Nonce)
 
 	logger.Info("got pow challenge solution")
 
-	ok, err := s.ddosProtector.CheckSolution(pow, powSolution.Nonce)
+	ok, err := s.ddosProtector.CheckSolution(challenge, client, powSolution.Nonce)
+	return s.checkResult(logger, ok, err)
+}
+
+// readSolution sends the challenge and reads the client solution. The challenge sent before
+// the late hello is sent again, the solution read in place of the late hello isn't read again.
+func (s *Server) readSolution(
+	conn net.Conn,
+	proto connProtocol,
+	client pow.ClientInfo,
+	deadline time.Time,
+) (pow.Challenge, PowChallengeSolution, error) {
+	if proto.solution != nil {
+		return *proto.challenge, *proto.solution, nil
+	}
+
+	var (
+		challenge pow.Challenge
+		err       error
+	)
+	if proto.challenge != nil {
+		challenge = *proto.challenge
+		if err := proto.codec.Encode(PowChallenge(challenge)); err != nil {
+			return challenge, PowChallengeSolution{}, fmt.Errorf("encode pow challenge error: %w", err)
+		}
+	} else if challenge, err = s.sendChallenge(proto.codec, client); err != nil {
+		return challenge, PowChallengeSolution{}, err
+	}
+
+	if err := conn.SetReadDeadline(phaseDeadline(deadline, s.solutionTimeout(challenge))); err != nil {
+		return challenge, PowChallengeSolution{}, fmt.Errorf("set solution deadline error: %w", err)
+	}
+
+	var powSolution PowChallengeSolution
+	if err := proto.codec.Decode(&powSolution); err != nil {
+		return challenge, powSolution, fmt.Errorf("decode pos challenge solution error: %w", err)
+	}
+	return challenge, powSolution, nil
+}
+
+// sendChallenge generates the challenge for the client and sends it.
+func (s *Server) sendChallenge(codec Codec, client pow.ClientInfo) (pow.Challenge, error) {
+	challenge, err := s.ddosProtector.GenerateChallenge(client)
 	if err != nil {
-		return false, fmt.Errorf("check solution error: %w", err)
+		return challenge, fmt.Errorf("generate solution error: %w", err)
 	}
 
-	return ok, nil
+	s.logger.Info("pow challenge generated", "client_addr", client.Addr, "data", challenge.Data, "difficulty", challenge.Difficulty)
+
+	if err := codec.Encode(PowChallenge(challenge)); err != nil {
+		return challenge, fmt.Errorf("encode pow challenge error: %w", err)
+	}
+	return challenge, nil
 }
+
+// checkResult treats the challenges rejected by the protector as unverified connections.
+func (s *Server) checkResult(logger *slog.Logger, ok bool, err error) (ErrorCode, error) {
+	if err != nil {
+		if errors.Is(err, pow.ErrChallengeExpired) {
+			logger.Warn("pow challenge expired", "err", err)
+			return CodeExpiredChallenge, nil
+		}
+		if errors.Is(err, pow.ErrChallengeRejected) {
+			logger.Warn("pow challenge rejected", "err", err)
+			return CodeInvalidSolution, nil
+		}
+		return "", fmt.Errorf("check solution error: %w", err)
+	}
+
+	if !ok {
+		return CodeInvalidSolution, nil
+	}
+	return "", nil
+}
+
+// clientInfo returns the connection metadata. The client address is the host without port,
+// so the challenges are bound to the client and not to the particular connection.
+func clientInfo(conn net.Conn) pow.ClientInfo {
+	// The unix socket clients are unnamed, they're told apart by the socket only.
+	if _, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		return pow.ClientInfo{Addr: unixAddrPrefix + conn.LocalAddr().String()}
+	}
+
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return pow.ClientInfo{Addr: addr}
+}
+
+type noopLoadReporter struct{}
+
+func (noopLoadReporter) ConnectionAccepted() {}
+func (noopLoadReporter) ConnectionClosed()   {}
+func (noopLoadReporter) VerificationFailed() {}
+
+type noopClientReporter struct{}
+
+func (noopClientReporter) ConnectionOpened(string)      {}
+func (noopClientReporter) VerificationSucceeded(string) {}
+func (noopClientReporter) VerificationFailed(string)    {}
+func (noopClientReporter) ConnectionTimedOut(string)    {}
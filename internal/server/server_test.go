@@ -1,13 +1,18 @@
 package server
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"log/slog"
 	"net"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
@@ -64,6 +69,52 @@ func TestServer_HandleConnection(t *testing.T) {
 			Quote:                 nil,
 			HandleConnErrExpected: false,
 		},
+		{
+			Name:                   "rejected_challenge",
+			GenerateChallengeError: nil,
+			GeneratedChallenge: pow.Challenge{
+				Data:       "test_data",
+				Difficulty: 10,
+			},
+			ClientChallengeSolutionRaw: `{"nonce":10}`,
+			ClientSolutionNonce:        10,
+			ChallengeSolutionCorrect: func() *bool {
+				t := false
+				return &t
+			}(),
+			CheckSolutionError:    pow.ErrChallengeExpired,
+			Quote:                 nil,
+			HandleConnErrExpected: false,
+		},
+		{
+			Name:                   "check_solution_error",
+			GenerateChallengeError: nil,
+			GeneratedChallenge: pow.Challenge{
+				Data:       "test_data",
+				Difficulty: 10,
+			},
+			ClientChallengeSolutionRaw: `{"nonce":10}`,
+			ClientSolutionNonce:        10,
+			ChallengeSolutionCorrect: func() *bool {
+				t := false
+				return &t
+			}(),
+			CheckSolutionError:    errors.New("test"),
+			Quote:                 nil,
+			HandleConnErrExpected: true,
+		},
+		{
+			Name:                   "redeemed_challenge_without_hello",
+			GenerateChallengeError: nil,
+			GeneratedChallenge: pow.Challenge{
+				Data:       "test_data",
+				Difficulty: 10,
+			},
+			ClientChallengeSolutionRaw: `{"nonce":30,"challenge":{"data":"earlier_data","difficulty":12,"issued_at":1,"expires_at":2,"signature":"abcd"}}`,
+			ChallengeSolutionCorrect:   nil,
+			Quote:                      nil,
+			HandleConnErrExpected:      true,
+		},
 		{
 			Name:                     "create_challenge_error",
 			GenerateChallengeError:   errors.New("test"),
@@ -89,11 +140,11 @@ func TestServer_HandleConnection(t *testing.T) {
 		t.Run(tc.Name, func(t *testing.T) {
 			srv, mockWisdomQuotes, mockDdosProtector := makeServerWithMocks(t)
 
-			mockDdosProtector.On("GenerateChallenge").
+			mockDdosProtector.On("GenerateChallenge", testClient).
 				Return(tc.GeneratedChallenge, tc.GenerateChallengeError).Once()
 
 			if tc.ChallengeSolutionCorrect != nil {
-				mockDdosProtector.On("CheckSolution", tc.GeneratedChallenge, tc.ClientSolutionNonce).
+				mockDdosProtector.On("CheckSolution", tc.GeneratedChallenge, testClient, tc.ClientSolutionNonce).
 					Return(*tc.ChallengeSolutionCorrect, tc.CheckSolutionError).Once()
 			}
 
@@ -149,6 +200,9 @@ func TestServer_HandleConnection(t *testing.T) {
 	}
 }
 
+// testClient is the client of net.Pipe connections.
+var testClient = pow.ClientInfo{Addr: "pipe"}
+
 func makeServerWithMocks(t *testing.T) (*Server, *mocks.WisdomQuotesGetter, *mocks.DdosProtector) {
 	mockDdosProtector := mocks.NewDdosProtector(t)
 	mockQuotesGetter := mocks.NewWisdomQuotesGetter(t)
@@ -159,3 +213,959 @@ func makeServerWithMocks(t *testing.T) (*Server, *mocks.WisdomQuotesGetter, *moc
 		slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
 	), mockQuotesGetter, mockDdosProtector
 }
+
+func TestServer_ReportsVerificationFailure(t *testing.T) {
+	mockDdosProtector := mocks.NewDdosProtector(t)
+	mockLoadReporter := mocks.NewLoadReporter(t)
+	mockClientReporter := mocks.NewClientReporter(t)
+	srv := NewServer(
+		Config{},
+		mockDdosProtector,
+		mocks.NewWisdomQuotesGetter(t),
+		slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+		WithLoadReporter(mockLoadReporter),
+		WithClientReporter(mockClientReporter),
+	)
+
+	challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+	mockDdosProtector.On("GenerateChallenge", testClient).Return(challenge, nil).Once()
+	mockDdosProtector.On("CheckSolution", challenge, testClient, uint64(20)).Return(false, nil).Once()
+	mockLoadReporter.On("VerificationFailed").Once()
+	mockClientReporter.On("ConnectionOpened", testClient.Addr).Once()
+	mockClientReporter.On("VerificationFailed", testClient.Addr).Once()
+
+	srvConn, cliConn := net.Pipe()
+
+	go func() {
+		var pc PowChallenge
+		if err := json.NewDecoder(cliConn).Decode(&pc); err != nil {
+			return
+		}
+		_, _ = io.WriteString(cliConn, `{"nonce":20}`)
+	}()
+
+	require.NoError(t, srv.handleConnection(srvConn))
+}
+
+func TestServer_ReportsClientTimeout(t *testing.T) {
+	mockDdosProtector := mocks.NewDdosProtector(t)
+	mockClientReporter := mocks.NewClientReporter(t)
+	srv := NewServer(
+		Config{HandleConnectionTimeout: 50 * time.Millisecond},
+		mockDdosProtector,
+		mocks.NewWisdomQuotesGetter(t),
+		slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+		WithClientReporter(mockClientReporter),
+	)
+
+	challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+	mockDdosProtector.On("GenerateChallenge", testClient).Return(challenge, nil).Once()
+	mockClientReporter.On("ConnectionOpened", testClient.Addr).Once()
+	mockClientReporter.On("ConnectionTimedOut", testClient.Addr).Once()
+
+	srvConn, cliConn := net.Pipe()
+	defer cliConn.Close()
+
+	go func() {
+		var pc PowChallenge
+		_ = json.NewDecoder(cliConn).Decode(&pc)
+	}()
+
+	require.ErrorIs(t, srv.handleConnection(srvConn), os.ErrDeadlineExceeded)
+}
+
+func TestServer_HandleConnectionPhaseTimeouts(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Cfg  Config
+		// Hello makes the client complete the hello exchange before Client.
+		Hello  bool
+		Client func(codec Codec, conn net.Conn)
+	}{
+		{
+			Name: "idle_hello",
+			Cfg:  Config{IdleTimeout: 50 * time.Millisecond},
+			Client: func(_ Codec, conn net.Conn) {
+				_, err := conn.Write([]byte(`{"Versions":[2`))
+				require.NoError(t, err)
+			},
+		},
+		{
+			Name:  "unsolved_challenge",
+			Cfg:   Config{IdleTimeout: 10 * time.Millisecond, SolutionTimeout: 50 * time.Millisecond, SolutionTimeoutDifficulty: 9},
+			Hello: true,
+			Client: func(codec Codec, _ net.Conn) {
+				var pc PowChallenge
+				require.NoError(t, codec.Decode(&pc))
+			},
+		},
+		{
+			Name:  "dribbled_solution",
+			Cfg:   Config{IdleTimeout: 50 * time.Millisecond, SolutionTimeout: 10 * time.Second, SolutionTimeoutDifficulty: 10},
+			Hello: true,
+			Client: func(codec Codec, conn net.Conn) {
+				var pc PowChallenge
+				require.NoError(t, codec.Decode(&pc))
+				_, err := conn.Write([]byte(`{"Nonce":`))
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mockDdosProtector := mocks.NewDdosProtector(t)
+			mockClientReporter := mocks.NewClientReporter(t)
+			tc.Cfg.HandleConnectionTimeout = 10 * time.Second
+			tc.Cfg.ProtocolProbeTimeout = 100 * time.Millisecond
+			srv := NewServer(
+				tc.Cfg,
+				mockDdosProtector,
+				mocks.NewWisdomQuotesGetter(t),
+				slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+				WithClientReporter(mockClientReporter),
+			)
+
+			challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+			mockDdosProtector.On("GenerateChallenge", testClient).Return(challenge, nil).Maybe()
+			mockClientReporter.On("ConnectionOpened", testClient.Addr).Once()
+			mockClientReporter.On("ConnectionTimedOut", testClient.Addr).Once()
+
+			srvConn, cliConn := net.Pipe()
+
+			cliExitChan := make(chan struct{})
+
+			go func() {
+				defer close(cliExitChan)
+
+				codec := NewJSONCodec(cliConn, cliConn, maxSolutionReadBytes)
+				if tc.Hello {
+					require.NoError(t, codec.Encode(ClientHello{Versions: []int{ProtocolVersion2}}))
+					var hello ServerHello
+					require.NoError(t, codec.Decode(&hello))
+				}
+				tc.Client(codec, cliConn)
+
+				// The error message is read until the server closes the connection.
+				_, _ = io.Copy(io.Discard, cliConn)
+			}()
+
+			start := time.Now()
+			require.ErrorIs(t, srv.handleConnection(srvConn), os.ErrDeadlineExceeded)
+			require.Less(t, time.Since(start), time.Second)
+
+			<-cliExitChan
+		})
+	}
+}
+
+func TestServer_HandleConnectionAgreedFeatures(t *testing.T) {
+	const stamp = "1:10:240301120000:pow.example.com::c2FsdA==:1f"
+
+	challenge := pow.Challenge{Data: "test_data", Difficulty: 10, HashcashResource: "pow.example.com"}
+	redeemed := pow.Challenge{Data: "earlier_data", Difficulty: 12, IssuedAt: 1, ExpiresAt: 2, Signature: "abcd"}
+	unsigned := pow.Challenge{Data: "earlier_data", Difficulty: 12}
+
+	testCases := []struct {
+		Name     string
+		Features []string
+		Solution PowChallengeSolution
+		// Code is the expected error code, the quote is expected when it's empty.
+		Code ErrorCode
+	}{
+		{
+			Name:     "hashcash_stamp",
+			Features: []string{FeatureHashcash},
+			Solution: PowChallengeSolution{Stamp: stamp},
+		},
+		{
+			Name:     "hashcash_stamp_not_agreed",
+			Features: []string{FeatureRedeem},
+			Solution: PowChallengeSolution{Stamp: stamp},
+			Code:     CodeProtocolError,
+		},
+		{
+			Name:     "redeemed_signed_challenge",
+			Features: []string{FeatureRedeem},
+			Solution: PowChallengeSolution{Nonce: 30, Challenge: (*PowChallenge)(&redeemed)},
+		},
+		{
+			Name:     "redeemed_unsigned_challenge",
+			Features: []string{FeatureRedeem},
+			Solution: PowChallengeSolution{Nonce: 30, Challenge: (*PowChallenge)(&unsigned)},
+			Code:     CodeInvalidSolution,
+		},
+		{
+			Name:     "redeemed_challenge_not_agreed",
+			Features: []string{FeatureHashcash},
+			Solution: PowChallengeSolution{Nonce: 30, Challenge: (*PowChallenge)(&redeemed)},
+			Code:     CodeProtocolError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mockDdosProtector := mocks.NewDdosProtector(t)
+			mockWisdomQuotes := mocks.NewWisdomQuotesGetter(t)
+			srv := NewServer(
+				Config{ProtocolProbeTimeout: 100 * time.Millisecond},
+				mockDdosProtector,
+				mockWisdomQuotes,
+				slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+			)
+
+			mockDdosProtector.On("GenerateChallenge", testClient).Return(challenge, nil).Once()
+			if tc.Code == "" {
+				mockWisdomQuotes.On("GetWisdomQuote").Return("test quote").Once()
+				if tc.Solution.Stamp != "" {
+					mockDdosProtector.On("CheckStamp", challenge, stamp).Return(true, nil).Once()
+				} else {
+					mockDdosProtector.On("CheckSolution", redeemed, testClient, tc.Solution.Nonce).Return(true, nil).Once()
+				}
+			}
+
+			srvConn, cliConn := net.Pipe()
+
+			cliExitChan := make(chan struct{})
+
+			go func() {
+				defer close(cliExitChan)
+
+				codec := NewJSONCodec(cliConn, cliConn, maxSolutionReadBytes)
+				require.NoError(t, codec.Encode(ClientHello{Versions: []int{ProtocolVersion2}, Features: tc.Features}))
+
+				var hello ServerHello
+				require.NoError(t, codec.Decode(&hello))
+				require.Equal(t, tc.Features, hello.Features)
+
+				var pc PowChallenge
+				require.NoError(t, codec.Decode(&pc))
+				require.NoError(t, codec.Encode(tc.Solution))
+
+				var wow WordOfWisdom
+				err := codec.Decode(&wow)
+				if tc.Code == "" {
+					require.NoError(t, err)
+					require.Equal(t, "test quote", wow.Text)
+					return
+				}
+				var errMsg ErrorMessage
+				require.ErrorAs(t, err, &errMsg)
+				require.Equal(t, tc.Code, errMsg.Code)
+			}()
+
+			err := srv.handleConnection(srvConn)
+			if tc.Code == CodeProtocolError {
+				require.ErrorIs(t, err, ErrFeatureNotAgreed)
+			} else {
+				require.NoError(t, err)
+			}
+
+			<-cliExitChan
+		})
+	}
+}
+
+func TestServer_HandleConnectionBinaryProtocol(t *testing.T) {
+	mockDdosProtector := mocks.NewDdosProtector(t)
+	mockWisdomQuotes := mocks.NewWisdomQuotesGetter(t)
+	srv := NewServer(
+		Config{ProtocolProbeTimeout: 100 * time.Millisecond},
+		mockDdosProtector,
+		mockWisdomQuotes,
+		slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+	)
+
+	challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+	mockDdosProtector.On("GenerateChallenge", testClient).Return(challenge, nil).Twice()
+	mockDdosProtector.On("CheckSolution", challenge, testClient, uint64(10)).Return(true, nil).Once()
+	mockDdosProtector.On("CheckSolution", challenge, testClient, uint64(20)).Return(false, nil).Once()
+	mockWisdomQuotes.On("GetWisdomQuote").Return("test quote").Once()
+
+	for nonce, expectedErr := range map[uint64]error{10: nil, 20: ErrorMessage{Code: CodeInvalidSolution, Message: errorTexts[CodeInvalidSolution]}} {
+		srvConn, cliConn := net.Pipe()
+
+		cliExitChan := make(chan struct{})
+
+		go func() {
+			defer close(cliExitChan)
+
+			_, err := cliConn.Write([]byte{BinaryMagic})
+			require.NoError(t, err)
+
+			codec := NewBinaryCodec(cliConn, cliConn, maxSolutionReadBytes)
+
+			var pc PowChallenge
+			require.NoError(t, codec.Decode(&pc))
+			require.Equal(t, PowChallenge(challenge), pc)
+
+			require.NoError(t, codec.Encode(PowChallengeSolution{Nonce: nonce}))
+
+			var wow WordOfWisdom
+			err = codec.Decode(&wow)
+			if expectedErr != nil {
+				require.Equal(t, expectedErr, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "test quote", wow.Text)
+		}()
+
+		require.NoError(t, srv.handleConnection(srvConn))
+
+		<-cliExitChan
+	}
+}
+
+func TestServer_HandleConnectionJSONFallback(t *testing.T) {
+	mockDdosProtector := mocks.NewDdosProtector(t)
+	mockWisdomQuotes := mocks.NewWisdomQuotesGetter(t)
+	srv := NewServer(
+		Config{ProtocolProbeTimeout: 10 * time.Millisecond},
+		mockDdosProtector,
+		mockWisdomQuotes,
+		slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+	)
+
+	challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+	mockDdosProtector.On("GenerateChallenge", testClient).Return(challenge, nil).Once()
+	mockDdosProtector.On("CheckSolution", challenge, testClient, uint64(10)).Return(true, nil).Once()
+	mockWisdomQuotes.On("GetWisdomQuote").Return("test quote").Once()
+
+	srvConn, cliConn := net.Pipe()
+
+	cliExitChan := make(chan struct{})
+
+	go func() {
+		defer close(cliExitChan)
+
+		var pc PowChallenge
+		require.NoError(t, json.NewDecoder(cliConn).Decode(&pc))
+
+		_, err := io.WriteString(cliConn, `{"nonce":10}`)
+		require.NoError(t, err)
+
+		var wow WordOfWisdom
+		require.NoError(t, json.NewDecoder(cliConn).Decode(&wow))
+		require.Equal(t, "test quote", wow.Text)
+	}()
+
+	require.NoError(t, srv.handleConnection(srvConn))
+
+	<-cliExitChan
+}
+
+func TestServer_HandleConnectionLateHello(t *testing.T) {
+	for _, binary := range []bool{false, true} {
+		srv, mockWisdomQuotes, mockDdosProtector := makeServerWithMocks(t)
+
+		// The challenge sent before the hello is sent again, it isn't generated twice.
+		challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+		mockDdosProtector.On("GenerateChallenge", testClient).Return(challenge, nil).Once()
+		mockDdosProtector.On("CheckSolution", challenge, testClient, uint64(10)).Return(true, nil).Once()
+		mockWisdomQuotes.On("GetWisdomQuote").Return("test quote").Once()
+
+		srvConn, cliConn := net.Pipe()
+
+		cliExitChan := make(chan struct{})
+
+		go func() {
+			defer close(cliExitChan)
+
+			r := bufio.NewReader(cliConn)
+			line, err := r.ReadBytes('\n')
+			require.NoError(t, err)
+			var pc PowChallenge
+			require.NoError(t, json.Unmarshal(line, &pc))
+			require.Equal(t, PowChallenge(challenge), pc)
+
+			codec := NewJSONCodec(r, cliConn, maxSolutionReadBytes)
+			if binary {
+				_, err := cliConn.Write([]byte{BinaryMagic})
+				require.NoError(t, err)
+				codec = NewBinaryCodec(r, cliConn, maxSolutionReadBytes)
+			}
+
+			require.NoError(t, codec.Encode(ClientHello{Versions: []int{ProtocolVersion2}}))
+			var hello ServerHello
+			require.NoError(t, codec.Decode(&hello))
+			require.Equal(t, ProtocolVersion2, hello.Version)
+
+			pc = PowChallenge{}
+			require.NoError(t, codec.Decode(&pc))
+			require.Equal(t, PowChallenge(challenge), pc)
+
+			require.NoError(t, codec.Encode(PowChallengeSolution{Nonce: 10}))
+			var wow WordOfWisdom
+			require.NoError(t, codec.Decode(&wow))
+			require.Equal(t, "test quote", wow.Text)
+		}()
+
+		require.NoError(t, srv.handleConnection(srvConn))
+
+		<-cliExitChan
+	}
+}
+
+func TestServer_HandleConnectionHandshake(t *testing.T) {
+	for _, binary := range []bool{false, true} {
+		mockDdosProtector := mocks.NewDdosProtector(t)
+		srv := NewServer(
+			Config{ProtocolProbeTimeout: 100 * time.Millisecond},
+			mockDdosProtector,
+			mocks.NewWisdomQuotesGetter(t),
+			slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+		)
+
+		challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+		mockDdosProtector.On("GenerateChallenge", testClient).Return(challenge, nil).Once()
+		mockDdosProtector.On("CheckSolution", challenge, testClient, uint64(20)).Return(false, nil).Once()
+
+		srvConn, cliConn := net.Pipe()
+
+		cliExitChan := make(chan struct{})
+
+		go func() {
+			defer close(cliExitChan)
+
+			codec := NewJSONCodec(cliConn, cliConn, maxSolutionReadBytes)
+			if binary {
+				_, err := cliConn.Write([]byte{BinaryMagic})
+				require.NoError(t, err)
+				codec = NewBinaryCodec(cliConn, cliConn, maxSolutionReadBytes)
+			}
+
+			require.NoError(t, codec.Encode(ClientHello{Versions: []int{2, 3}, Features: []string{FeatureHashcash, "unknown"}}))
+
+			var hello ServerHello
+			require.NoError(t, codec.Decode(&hello))
+			require.Equal(t, ServerHello{Version: ProtocolVersion2, Features: []string{FeatureHashcash}}, hello)
+
+			var pc PowChallenge
+			require.NoError(t, codec.Decode(&pc))
+			require.NoError(t, codec.Encode(PowChallengeSolution{Nonce: 20}))
+
+			var wow WordOfWisdom
+			require.Equal(t, ErrorMessage{Code: CodeInvalidSolution, Message: errorTexts[CodeInvalidSolution]}, codec.Decode(&wow))
+		}()
+
+		require.NoError(t, srv.handleConnection(srvConn))
+
+		<-cliExitChan
+	}
+}
+
+func TestServer_HandleConnectionIncompatibleVersion(t *testing.T) {
+	mockClientReporter := mocks.NewClientReporter(t)
+	srv := NewServer(
+		Config{ProtocolProbeTimeout: 100 * time.Millisecond},
+		mocks.NewDdosProtector(t),
+		mocks.NewWisdomQuotesGetter(t),
+		slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+		WithClientReporter(mockClientReporter),
+	)
+
+	mockClientReporter.On("ConnectionOpened", testClient.Addr).Once()
+	mockClientReporter.On("VerificationFailed", testClient.Addr).Once()
+
+	srvConn, cliConn := net.Pipe()
+
+	cliExitChan := make(chan struct{})
+
+	go func() {
+		defer close(cliExitChan)
+
+		codec := NewJSONCodec(cliConn, cliConn, maxSolutionReadBytes)
+		require.NoError(t, codec.Encode(ClientHello{Versions: []int{7}}))
+
+		var hello ServerHello
+		var errMsg ErrorMessage
+		require.ErrorAs(t, codec.Decode(&hello), &errMsg)
+		require.Equal(t, CodeProtocolError, errMsg.Code)
+		require.Contains(t, errMsg.Message, "client versions [7]")
+	}()
+
+	require.ErrorIs(t, srv.handleConnection(srvConn), ErrIncompatibleProtocol)
+
+	<-cliExitChan
+}
+
+func TestServer_HandleConnectionErrorCodes(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		Solution           []byte
+		CheckSolutionError error
+		ExpectedCode       ErrorCode
+		HandleConnErr      bool
+	}{
+		{
+			Name:         "invalid_solution",
+			Solution:     PowChallengeSolution{Nonce: 20}.appendBinary(nil),
+			ExpectedCode: CodeInvalidSolution,
+		},
+		{
+			Name:               "expired_challenge",
+			Solution:           PowChallengeSolution{Nonce: 20}.appendBinary(nil),
+			CheckSolutionError: pow.ErrChallengeExpired,
+			ExpectedCode:       CodeExpiredChallenge,
+		},
+		{
+			Name:               "internal_error",
+			Solution:           PowChallengeSolution{Nonce: 20}.appendBinary(nil),
+			CheckSolutionError: errors.New("test error"),
+			ExpectedCode:       CodeInternalError,
+			HandleConnErr:      true,
+		},
+		{
+			Name:          "protocol_error",
+			Solution:      []byte{2},
+			ExpectedCode:  CodeProtocolError,
+			HandleConnErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mockDdosProtector := mocks.NewDdosProtector(t)
+			srv := NewServer(
+				Config{ProtocolProbeTimeout: 100 * time.Millisecond},
+				mockDdosProtector,
+				mocks.NewWisdomQuotesGetter(t),
+				slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+			)
+
+			challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+			mockDdosProtector.On("GenerateChallenge", testClient).Return(challenge, nil).Once()
+			if tc.ExpectedCode != CodeProtocolError {
+				mockDdosProtector.On("CheckSolution", challenge, testClient, uint64(20)).
+					Return(false, tc.CheckSolutionError).Once()
+			}
+
+			srvConn, cliConn := net.Pipe()
+
+			cliExitChan := make(chan struct{})
+
+			go func() {
+				defer close(cliExitChan)
+
+				_, err := cliConn.Write([]byte{BinaryMagic})
+				require.NoError(t, err)
+
+				codec := NewBinaryCodec(cliConn, cliConn, maxSolutionReadBytes)
+
+				var pc PowChallenge
+				require.NoError(t, codec.Decode(&pc))
+
+				frame := []byte{binaryVersion, byte(MessageSolution), 0, 0, 0, byte(len(tc.Solution))}
+				_, err = cliConn.Write(append(frame, tc.Solution...))
+				require.NoError(t, err)
+
+				var wow WordOfWisdom
+				var errMsg ErrorMessage
+				require.ErrorAs(t, codec.Decode(&wow), &errMsg)
+				require.Equal(t, tc.ExpectedCode, errMsg.Code)
+			}()
+
+			err := srv.handleConnection(srvConn)
+			if tc.HandleConnErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			<-cliExitChan
+		})
+	}
+}
+
+func TestServer_HandleConnectionSession(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Cfg      Config
+		Quotes   int
+		Requests int
+		Idle     time.Duration
+	}{
+		{
+			Name:     "max_quotes",
+			Cfg:      Config{ProtocolProbeTimeout: 100 * time.Millisecond, SessionMaxQuotes: 3},
+			Quotes:   3,
+			Requests: 3,
+		},
+		{
+			Name:     "duration",
+			Cfg:      Config{ProtocolProbeTimeout: 100 * time.Millisecond, SessionMaxQuotes: 3, SessionDuration: 50 * time.Millisecond},
+			Quotes:   2,
+			Requests: 2,
+			Idle:     100 * time.Millisecond,
+		},
+		{
+			Name:     "idle",
+			Cfg:      Config{ProtocolProbeTimeout: 100 * time.Millisecond, SessionMaxQuotes: 3, IdleTimeout: 50 * time.Millisecond},
+			Quotes:   2,
+			Requests: 2,
+			Idle:     100 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mockDdosProtector := mocks.NewDdosProtector(t)
+			mockWisdomQuotes := mocks.NewWisdomQuotesGetter(t)
+			srv := NewServer(
+				tc.Cfg,
+				mockDdosProtector,
+				mockWisdomQuotes,
+				slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+			)
+
+			challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+			mockDdosProtector.On("GenerateChallenge", testClient).Return(challenge, nil).Once()
+			mockDdosProtector.On("CheckSolution", challenge, testClient, uint64(10)).Return(true, nil).Once()
+			mockWisdomQuotes.On("GetWisdomQuote").Return("test quote").Times(tc.Quotes)
+
+			srvConn, cliConn := net.Pipe()
+
+			cliExitChan := make(chan struct{})
+
+			go func() {
+				defer close(cliExitChan)
+
+				codec := NewJSONCodec(cliConn, cliConn, maxSolutionReadBytes)
+				require.NoError(t, codec.Encode(ClientHello{Versions: []int{ProtocolVersion2}, Features: []string{FeatureSession}}))
+
+				var hello ServerHello
+				require.NoError(t, codec.Decode(&hello))
+				require.Equal(t, []string{FeatureSession}, hello.Features)
+
+				var pc PowChallenge
+				require.NoError(t, codec.Decode(&pc))
+				require.NoError(t, codec.Encode(PowChallengeSolution{Nonce: 10}))
+
+				var wow WordOfWisdom
+				require.NoError(t, codec.Decode(&wow))
+
+				for i := 1; i < tc.Requests; i++ {
+					require.NoError(t, codec.Encode(QuoteRequest{}))
+					require.NoError(t, codec.Decode(&wow))
+					require.Equal(t, "test quote", wow.Text)
+				}
+
+				if tc.Idle > 0 {
+					// The expired session error is sent without waiting for the request.
+					time.Sleep(tc.Idle)
+				} else {
+					require.NoError(t, codec.Encode(QuoteRequest{}))
+				}
+
+				var errMsg ErrorMessage
+				require.ErrorAs(t, codec.Decode(&wow), &errMsg)
+				require.Equal(t, CodeSessionExpired, errMsg.Code)
+			}()
+
+			require.NoError(t, srv.handleConnection(srvConn))
+
+			<-cliExitChan
+		})
+	}
+}
+
+func TestServer_HandleConnectionAccessToken(t *testing.T) {
+	const (
+		testToken          = "test_token"
+		testTokenExpiresAt = int64(1700000000)
+	)
+
+	testCases := []struct {
+		Name          string
+		Token         string
+		TokenAccepted bool
+	}{
+		{
+			Name: "token_issued",
+		},
+		{
+			Name:          "token_accepted",
+			Token:         testToken,
+			TokenAccepted: true,
+		},
+		{
+			Name:  "token_rejected",
+			Token: "invalid_token",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mockDdosProtector := mocks.NewDdosProtector(t)
+			mockWisdomQuotes := mocks.NewWisdomQuotesGetter(t)
+			mockAccessTokens := mocks.NewAccessTokenIssuer(t)
+			srv := NewServer(
+				Config{ProtocolProbeTimeout: 100 * time.Millisecond},
+				mockDdosProtector,
+				mockWisdomQuotes,
+				slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+				WithAccessTokens(mockAccessTokens),
+			)
+
+			mockWisdomQuotes.On("GetWisdomQuote").Return("test quote").Once()
+
+			challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+			if tc.Token != "" {
+				var err error
+				if !tc.TokenAccepted {
+					err = pow.ErrInvalidAccessToken
+				}
+				mockAccessTokens.On("CheckToken", tc.Token, testClient).Return(tc.TokenAccepted, err).Once()
+			}
+			if !tc.TokenAccepted {
+				mockDdosProtector.On("GenerateChallenge", testClient).Return(challenge, nil).Once()
+				mockDdosProtector.On("CheckSolution", challenge, testClient, uint64(10)).Return(true, nil).Once()
+				mockAccessTokens.On("IssueToken", testClient).Return(testToken, time.Unix(testTokenExpiresAt, 0), nil).Once()
+			}
+
+			srvConn, cliConn := net.Pipe()
+
+			cliExitChan := make(chan struct{})
+
+			go func() {
+				defer close(cliExitChan)
+
+				codec := NewJSONCodec(cliConn, cliConn, maxSolutionReadBytes)
+				require.NoError(t, codec.Encode(ClientHello{
+					Versions: []int{ProtocolVersion2},
+					Features: []string{FeatureAccessToken},
+					Token:    tc.Token,
+				}))
+
+				var hello ServerHello
+				require.NoError(t, codec.Decode(&hello))
+				require.Equal(t, ServerHello{
+					Version:       ProtocolVersion2,
+					Features:      []string{FeatureAccessToken},
+					TokenAccepted: tc.TokenAccepted,
+				}, hello)
+
+				expectedWow := WordOfWisdom{Text: "test quote"}
+				if !tc.TokenAccepted {
+					var pc PowChallenge
+					require.NoError(t, codec.Decode(&pc))
+					require.NoError(t, codec.Encode(PowChallengeSolution{Nonce: 10}))
+
+					expectedWow.Token, expectedWow.TokenExpiresAt = testToken, testTokenExpiresAt
+				}
+
+				var wow WordOfWisdom
+				require.NoError(t, codec.Decode(&wow))
+				require.Equal(t, expectedWow, wow)
+			}()
+
+			require.NoError(t, srv.handleConnection(srvConn))
+
+			<-cliExitChan
+		})
+	}
+}
+
+func TestServer_ServeConnectionLimits(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Config Config
+		Code   ErrorCode
+	}{
+		{
+			Name:   "total limit",
+			Config: Config{MaxConnections: 3},
+			Code:   CodeServerOverloaded,
+		},
+		{
+			Name:   "per ip limit",
+			Config: Config{MaxConnections: 10, MaxConnectionsPerIP: 3, ConnectionLimitV4Bits: 24},
+			Code:   CodeRateLimited,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, addr := serveWithLimits(t, tc.Config)
+
+			for i := 0; i < 3; i++ {
+				conn, codec := dialWithHello(t, addr)
+				defer conn.Close()
+
+				var hello ServerHello
+				require.NoError(t, codec.Decode(&hello))
+				var pc PowChallenge
+				require.NoError(t, codec.Decode(&pc))
+			}
+
+			for i := 0; i < 5; i++ {
+				conn, codec := dialWithHello(t, addr)
+				defer conn.Close()
+
+				var hello ServerHello
+				require.Equal(t, ErrorMessage{Code: tc.Code, Message: errorTexts[tc.Code]}, codec.Decode(&hello))
+			}
+		})
+	}
+}
+
+func TestServer_ServeConnectionQueue(t *testing.T) {
+	srv, addr := serveWithLimits(t, Config{
+		MaxConnections:         1,
+		ConnectionQueueSize:    1,
+		ConnectionQueueTimeout: 5 * time.Second,
+	})
+
+	admitted, admittedCodec := dialWithHello(t, addr)
+	var hello ServerHello
+	require.NoError(t, admittedCodec.Decode(&hello))
+
+	queued, queuedCodec := dialWithHello(t, addr)
+	defer queued.Close()
+	require.Eventually(t, func() bool {
+		srv.limiter.mu.Lock()
+		defer srv.limiter.mu.Unlock()
+		return srv.limiter.queued == 1
+	}, time.Second, time.Millisecond)
+
+	// The queue is full, so the connection is rejected without waiting.
+	rejected, rejectedCodec := dialWithHello(t, addr)
+	defer rejected.Close()
+	require.Equal(t, ErrorMessage{Code: CodeServerOverloaded, Message: errorTexts[CodeServerOverloaded]},
+		rejectedCodec.Decode(&hello))
+
+	require.NoError(t, admitted.Close())
+
+	require.NoError(t, queuedCodec.Decode(&hello))
+	require.Equal(t, ProtocolVersion2, hello.Version)
+}
+
+func TestServer_ServeGracefulShutdown(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		GracePeriod time.Duration
+		// Solve makes the client finish the exchange after the shutdown starts.
+		Solve bool
+	}{
+		{Name: "drained", GracePeriod: 10 * time.Second, Solve: true},
+		{Name: "grace_period_exceeded", GracePeriod: 100 * time.Millisecond},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mockDdosProtector := mocks.NewDdosProtector(t)
+			mockWisdomQuotes := mocks.NewWisdomQuotesGetter(t)
+			srv := NewServer(
+				Config{
+					ProtocolProbeTimeout:    100 * time.Millisecond,
+					HandleConnectionTimeout: 10 * time.Second,
+					ShutdownGracePeriod:     tc.GracePeriod,
+				},
+				mockDdosProtector,
+				mockWisdomQuotes,
+				slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+			)
+
+			challenge := pow.Challenge{Data: "test_data", Difficulty: 10}
+			mockDdosProtector.On("GenerateChallenge", mock.Anything).Return(challenge, nil).Once()
+			if tc.Solve {
+				mockDdosProtector.On("CheckSolution", challenge, mock.Anything, uint64(10)).Return(true, nil).Once()
+				mockWisdomQuotes.On("GetWisdomQuote").Return("test quote").Once()
+			}
+
+			addr, stop := startServer(t, srv)
+
+			conn, codec := dialWithHello(t, addr)
+			defer conn.Close()
+
+			var hello ServerHello
+			require.NoError(t, codec.Decode(&hello))
+			var pc PowChallenge
+			require.NoError(t, codec.Decode(&pc))
+
+			stopErrChan := make(chan error, 1)
+			go func() {
+				stopErrChan <- stop()
+			}()
+
+			// The listener is closed at once, the in-flight connection is kept.
+			require.Eventually(t, func() bool {
+				newConn, err := net.Dial("tcp", addr)
+				if err == nil {
+					newConn.Close()
+				}
+				return err != nil
+			}, time.Second, 10*time.Millisecond)
+
+			if !tc.Solve {
+				var wow WordOfWisdom
+				require.ErrorIs(t, codec.Decode(&wow), io.EOF)
+				require.ErrorIs(t, <-stopErrChan, context.Canceled)
+				return
+			}
+
+			select {
+			case <-stopErrChan:
+				require.Fail(t, "server stopped before the connection finished")
+			default:
+			}
+
+			require.NoError(t, codec.Encode(PowChallengeSolution{Nonce: 10}))
+			var wow WordOfWisdom
+			require.NoError(t, codec.Decode(&wow))
+			require.Equal(t, "test quote", wow.Text)
+
+			require.ErrorIs(t, <-stopErrChan, context.Canceled)
+		})
+	}
+}
+
+// serveWithLimits starts the server with the connection limits of cfg and returns its address.
+func serveWithLimits(t *testing.T, cfg Config) (*Server, string) {
+	mockDdosProtector := mocks.NewDdosProtector(t)
+	mockDdosProtector.On("GenerateChallenge", mock.Anything).
+		Return(pow.Challenge{Data: "test_data", Difficulty: 10}, nil).Maybe()
+
+	cfg.ProtocolProbeTimeout = 100 * time.Millisecond
+	cfg.HandleConnectionTimeout = 10 * time.Second
+	srv := NewServer(
+		cfg,
+		mockDdosProtector,
+		mocks.NewWisdomQuotesGetter(t),
+		slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+	)
+
+	addr, stop := startServer(t, srv)
+	t.Cleanup(func() { _ = stop() })
+
+	return srv, addr
+}
+
+// startServer serves on the loopback listener and returns its address and the function
+// stopping the server, it returns the Serve error.
+func startServer(t *testing.T, srv *Server) (string, func() error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErrChan := make(chan error, 1)
+	go func() {
+		serveErrChan <- srv.Serve(ctx, listener)
+	}()
+
+	return listener.Addr().String(), func() error {
+		cancel()
+		return <-serveErrChan
+	}
+}
+
+func dialWithHello(t *testing.T, addr string) (net.Conn, Codec) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	require.NoError(t, err)
+	require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+
+	codec := NewJSONCodec(conn, conn, maxSolutionReadBytes)
+	require.NoError(t, codec.Encode(ClientHello{Versions: []int{ProtocolVersion2}}))
+	return conn, codec
+}
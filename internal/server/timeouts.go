@@ -0,0 +1,116 @@
+package server
+
+import (
+	"math"
+	"net"
+	"time"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+// phaseDeadline returns the deadline of the phase lasting timeout which ends not later than
+// the connection deadline end, the zero timeout and end aren't limiting.
+func phaseDeadline(end time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return end
+	}
+	deadline := time.Now().Add(timeout)
+	if !end.IsZero() && end.Before(deadline) {
+		return end
+	}
+	return deadline
+}
+
+// argon2TimeoutCost is the memory in KiB times the iterations of the Argon2 hash the
+// SolutionTimeoutArgon2Difficulty is given for.
+const argon2TimeoutCost = 64 << 10
+
+// solutionTimeout returns the time the client has to solve the challenge, it's SolutionTimeout
+// for the challenges of SolutionTimeoutDifficulty bits and doubles with every extra bit. The
+// Argon2 hashes are much more expensive, so their timeout is scaled by the hash cost from
+// SolutionTimeoutArgon2Difficulty instead. It's never shorter than IdleTimeout, so the easy
+// challenges leave time for the network round trip.
+func (s *Server) solutionTimeout(challenge pow.Challenge) time.Duration {
+	if s.cfg.SolutionTimeout <= 0 {
+		return 0
+	}
+
+	timeout, bits := float64(s.cfg.SolutionTimeout), challenge.DifficultyBits()-s.cfg.SolutionTimeoutDifficulty
+	if params := challenge.Argon2; params != nil {
+		timeout *= float64(params.Memory) * float64(params.Iterations) / argon2TimeoutCost
+		bits = challenge.DifficultyBits() - s.cfg.SolutionTimeoutArgon2Difficulty
+	}
+
+	scaled := math.Ldexp(timeout, bits)
+	if scaled >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return max(time.Duration(scaled), s.cfg.IdleTimeout)
+}
+
+// timeoutConn applies the write and idle read timeouts within the connection deadline. Once
+// a read returns data, every following read must return data within the idle timeout until
+// the read deadline is set again, so the clients can't dribble the message byte by byte.
+type timeoutConn struct {
+	net.Conn
+	end          time.Time
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+
+	readDeadline time.Time
+	reading      bool
+}
+
+func newTimeoutConn(conn net.Conn, end time.Time, writeTimeout, idleTimeout time.Duration) *timeoutConn {
+	return &timeoutConn{
+		Conn:         conn,
+		end:          end,
+		writeTimeout: writeTimeout,
+		idleTimeout:  idleTimeout,
+		readDeadline: end,
+	}
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if c.reading && c.idleTimeout > 0 {
+		if err := c.Conn.SetReadDeadline(phaseDeadline(c.readDeadline, c.idleTimeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.reading = true
+	}
+	return n, err
+}
+
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		if err := c.Conn.SetWriteDeadline(phaseDeadline(c.end, c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *timeoutConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline, c.reading = t, false
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *timeoutConn) SetDeadline(t time.Time) error {
+	c.readDeadline, c.reading = t, false
+	return c.Conn.SetDeadline(t)
+}
+
+// CloseWrite shuts down the write side of the wrapped connection.
+func (c *timeoutConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+// clearTimeouts stops applying the timeouts and clears the connection deadline.
+func (c *timeoutConn) clearTimeouts() error {
+	c.end, c.writeTimeout, c.idleTimeout = time.Time{}, 0, 0
+	return c.SetDeadline(time.Time{})
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+	mocks "github.com/nikvakhrameev/pow_tcp_server/mocks/internal_/server"
+)
+
+func TestServer_SolutionTimeout(t *testing.T) {
+	srv := NewServer(
+		Config{
+			SolutionTimeout:                 time.Minute,
+			SolutionTimeoutDifficulty:       28,
+			SolutionTimeoutArgon2Difficulty: 6,
+			IdleTimeout:                     10 * time.Second,
+		},
+		mocks.NewDdosProtector(t),
+		mocks.NewWisdomQuotesGetter(t),
+		slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+	)
+
+	require.Equal(t, time.Minute, srv.solutionTimeout(pow.Challenge{Difficulty: 28}))
+	require.Equal(t, 4*time.Minute, srv.solutionTimeout(pow.Challenge{Difficulty: 30}))
+	require.Equal(t, 30*time.Second, srv.solutionTimeout(pow.Challenge{Difficulty: 27}))
+	require.Equal(t, 10*time.Second, srv.solutionTimeout(pow.Challenge{Difficulty: 6}))
+	require.Equal(t, 2*time.Minute, srv.solutionTimeout(pow.Challenge{Target: pow.EncodeTarget(pow.TargetForWork(1 << 29))}))
+	require.Equal(t, time.Duration(1<<63-1), srv.solutionTimeout(pow.Challenge{Difficulty: 256}))
+
+	argon2 := func(difficulty int, memory, iterations uint32) pow.Challenge {
+		return pow.Challenge{Difficulty: difficulty, Argon2: &pow.Argon2Params{Memory: memory, Iterations: iterations, Threads: 1}}
+	}
+	require.Equal(t, time.Minute, srv.solutionTimeout(argon2(6, 64<<10, 1)))
+	require.Equal(t, 8*time.Minute, srv.solutionTimeout(argon2(12, 8<<10, 1)))
+	require.Equal(t, 16*time.Minute, srv.solutionTimeout(argon2(12, 8<<10, 2)))
+	require.Equal(t, 10*time.Second, srv.solutionTimeout(argon2(6, 8<<10, 1)))
+}
+
+func TestPhaseDeadline(t *testing.T) {
+	end := time.Now().Add(time.Minute)
+
+	require.Equal(t, end, phaseDeadline(end, 0))
+	require.Equal(t, end, phaseDeadline(end, time.Hour))
+	require.WithinDuration(t, time.Now().Add(time.Second), phaseDeadline(end, time.Second), 100*time.Millisecond)
+	require.WithinDuration(t, time.Now().Add(time.Hour), phaseDeadline(time.Time{}, time.Hour), 100*time.Millisecond)
+	require.True(t, phaseDeadline(time.Time{}, 0).IsZero())
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM encoded server certificate chain and key, TLS is
+	// enabled when they're set.
+	CertFile   string `envconfig:"CERT_FILE"`
+	KeyFile    string `envconfig:"KEY_FILE"`
+	MinVersion string `envconfig:"MIN_VERSION" default:"1.2"`
+	// ClientCAFile enables the optional client certificates, the clients presenting the
+	// certificate issued by one of its CAs are trusted.
+	ClientCAFile string `envconfig:"CLIENT_CA_FILE"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion returns the TLS version of its number, e.g. 1.3, the empty one is the
+// crypto/tls default.
+func ParseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unknown tls version %q", version)
+	}
+	return v, nil
+}
+
+// LoadCertPool returns the pool of the PEM encoded certificates of the file.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read certificates file error: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %v", file)
+	}
+	return pool, nil
+}
+
+// NewTLSConfig returns the server TLS config, the client certificates are verified when
+// they're presented but aren't required.
+func NewTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate error: %w", err)
+	}
+
+	minVersion, err := ParseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+	}
+
+	if cfg.ClientCAFile != "" {
+		if tlsConfig.ClientCAs, err = LoadCertPool(cfg.ClientCAFile); err != nil {
+			return nil, fmt.Errorf("load client CAs error: %w", err)
+		}
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsHandshake performs the TLS handshake within the idle timeout and reports whether the
+// client presented the trusted certificate. The other connections have no handshake.
+func (s *Server) tlsHandshake(conn net.Conn, deadline time.Time) (bool, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return false, nil
+	}
+
+	if err := conn.SetDeadline(phaseDeadline(deadline, s.cfg.IdleTimeout)); err != nil {
+		return false, fmt.Errorf("set handshake deadline error: %w", err)
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return false, fmt.Errorf("tls handshake error: %w", err)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return false, fmt.Errorf("restore connection deadline error: %w", err)
+	}
+
+	return len(tlsConn.ConnectionState().VerifiedChains) > 0, nil
+}
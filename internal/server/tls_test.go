@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+	"github.com/nikvakhrameev/pow_tcp_server/internal/tlstest"
+	mocks "github.com/nikvakhrameev/pow_tcp_server/mocks/internal_/server"
+)
+
+func TestServer_ServeTLS(t *testing.T) {
+	certs := tlstest.Generate(t)
+	otherCerts := tlstest.Generate(t)
+
+	testCases := []struct {
+		Name    string
+		Certs   *tlstest.Certificates
+		Trusted bool
+		Fails   bool
+	}{
+		{Name: "no_client_certificate"},
+		{Name: "trusted_client_certificate", Certs: &certs, Trusted: true},
+		{Name: "untrusted_client_certificate", Certs: &otherCerts, Fails: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mockDdosProtector := mocks.NewDdosProtector(t)
+			mockClientReporter := mocks.NewClientReporter(t)
+			srv := NewServer(
+				Config{
+					TLS: TLSConfig{
+						CertFile:     certs.ServerCertFile,
+						KeyFile:      certs.ServerKeyFile,
+						MinVersion:   "1.3",
+						ClientCAFile: certs.CAFile,
+					},
+					ProtocolProbeTimeout:    100 * time.Millisecond,
+					HandleConnectionTimeout: 10 * time.Second,
+				},
+				mockDdosProtector,
+				mocks.NewWisdomQuotesGetter(t),
+				slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+				WithClientReporter(mockClientReporter),
+			)
+
+			client := pow.ClientInfo{Addr: "127.0.0.1", Trusted: tc.Trusted}
+			mockClientReporter.On("ConnectionOpened", client.Addr).Once()
+			mockClientReporter.On("VerificationFailed", client.Addr).Once()
+			if !tc.Fails {
+				mockDdosProtector.On("GenerateChallenge", client).
+					Return(pow.Challenge{Data: "test_data", Difficulty: 10}, nil).Once()
+				mockDdosProtector.On("CheckSolution", pow.Challenge{Data: "test_data", Difficulty: 10}, client, uint64(10)).
+					Return(false, nil).Once()
+			}
+
+			addr, stop := startServer(t, srv)
+			defer stop()
+
+			rootCAs, err := LoadCertPool(certs.CAFile)
+			require.NoError(t, err)
+			tlsConfig := &tls.Config{RootCAs: rootCAs}
+			if tc.Certs != nil {
+				cert, err := tls.LoadX509KeyPair(tc.Certs.ClientCertFile, tc.Certs.ClientKeyFile)
+				require.NoError(t, err)
+				tlsConfig.Certificates = []tls.Certificate{cert}
+			}
+
+			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr, tlsConfig)
+			require.NoError(t, err)
+			defer conn.Close()
+			require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+
+			codec := NewJSONCodec(conn, conn, maxSolutionReadBytes)
+			if tc.Fails {
+				// The TLS 1.3 client learns about the rejected certificate on the first read.
+				var hello ServerHello
+				require.Error(t, codec.Decode(&hello))
+				return
+			}
+
+			require.NoError(t, codec.Encode(ClientHello{Versions: []int{ProtocolVersion2}}))
+			var hello ServerHello
+			require.NoError(t, codec.Decode(&hello))
+			var pc PowChallenge
+			require.NoError(t, codec.Decode(&pc))
+			require.NoError(t, codec.Encode(PowChallengeSolution{Nonce: 10}))
+
+			var wow WordOfWisdom
+			require.Equal(t, ErrorMessage{Code: CodeInvalidSolution, Message: errorTexts[CodeInvalidSolution]}, codec.Decode(&wow))
+		})
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certs := tlstest.Generate(t)
+
+	tlsConfig, err := NewTLSConfig(TLSConfig{CertFile: certs.ServerCertFile, KeyFile: certs.ServerKeyFile, MinVersion: "1.2"})
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	require.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+
+	_, err = NewTLSConfig(TLSConfig{CertFile: certs.ServerCertFile, KeyFile: certs.ServerKeyFile, MinVersion: "2.0"})
+	require.ErrorContains(t, err, "unknown tls version")
+
+	_, err = NewTLSConfig(TLSConfig{CertFile: certs.ServerCertFile, KeyFile: certs.ClientKeyFile})
+	require.ErrorContains(t, err, "load server certificate error")
+
+	_, err = NewTLSConfig(TLSConfig{CertFile: certs.ServerCertFile, KeyFile: certs.ServerKeyFile, ClientCAFile: certs.ServerKeyFile})
+	require.ErrorContains(t, err, "no certificates")
+}
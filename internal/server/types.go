@@ -2,28 +2,136 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
 )
 
 type Config struct {
-	Port                    string        `envconfig:"PORT" default:":8085"`
+	Port string `envconfig:"PORT" default:":8085"`
+	// ListenAddrs are the addresses the server listens on instead of Port, e.g. :8085, [::1]:8085
+	// or unix:/run/pow.sock for the unix socket.
+	ListenAddrs []string  `envconfig:"LISTEN_ADDRS"`
+	TLS         TLSConfig `envconfig:"TLS"`
+	// ProxyProtocolTrustedProxies are the IPs and CIDRs of the load balancers sending the PROXY
+	// protocol v1 or v2 header, the client address is taken from it. The header is required on
+	// their connections and isn't read on the other ones.
+	ProxyProtocolTrustedProxies []string `envconfig:"PROXY_PROTOCOL_TRUSTED_PROXIES"`
+	// HandleConnectionTimeout caps the whole connection, the phase timeouts below are applied within it.
 	HandleConnectionTimeout time.Duration `envconfig:"HANDLE_TIMEOUT" default:"10m"`
+	// IdleTimeout is how long the server waits for the client messages which need no work,
+	// i.e. the hello and the session quote requests, and for the rest of a message once its
+	// first bytes are received.
+	IdleTimeout  time.Duration `envconfig:"IDLE_TIMEOUT" default:"10s"`
+	WriteTimeout time.Duration `envconfig:"WRITE_TIMEOUT" default:"10s"`
+	// SolutionTimeout is how long the client solves the challenge of SolutionTimeoutDifficulty
+	// bits, it doubles with every extra bit of the challenge difficulty.
+	SolutionTimeout           time.Duration `envconfig:"SOLUTION_TIMEOUT" default:"1m"`
+	SolutionTimeoutDifficulty int           `envconfig:"SOLUTION_TIMEOUT_DIFFICULTY" default:"28"`
+	// SolutionTimeoutArgon2Difficulty is the SolutionTimeoutDifficulty of the Argon2 challenges
+	// hashing 64 MiB once, the timeout is scaled by the memory and iterations of the challenge.
+	SolutionTimeoutArgon2Difficulty int `envconfig:"SOLUTION_TIMEOUT_ARGON2_DIFFICULTY" default:"6"`
+	// ProtocolProbeTimeout is how long the server waits for the binary protocol magic byte
+	// and the client hello before falling back to the legacy JSON exchange. The legacy clients
+	// send nothing first and get the challenge that much later, so it's opt-in. When it's zero
+	// the challenge is sent at once and the hello is read in place of the solution.
+	ProtocolProbeTimeout time.Duration `envconfig:"PROTOCOL_PROBE_TIMEOUT"`
+	// SessionMaxQuotes is the number of quotes the clients supporting sessions get for one
+	// solved challenge, the sessions are disabled when it's less than 2.
+	SessionMaxQuotes int `envconfig:"SESSION_MAX_QUOTES" default:"10"`
+	// SessionDuration limits the session time, only the connection timeout limits it when it's zero.
+	SessionDuration time.Duration `envconfig:"SESSION_DURATION" default:"1m"`
+	// MaxConnections caps the in-flight connections, it's unlimited when zero.
+	MaxConnections int `envconfig:"MAX_CONNECTIONS" default:"10000"`
+	// MaxConnectionsPerIP caps the in-flight connections of the client subnet, it's unlimited when zero.
+	MaxConnectionsPerIP   int `envconfig:"MAX_CONNECTIONS_PER_IP" default:"64"`
+	ConnectionLimitV4Bits int `envconfig:"CONNECTION_LIMIT_V4_BITS" default:"32"`
+	ConnectionLimitV6Bits int `envconfig:"CONNECTION_LIMIT_V6_BITS" default:"64"`
+	// UnixClientLimits applies the per client connection limit and the reputation to the unix
+	// socket clients. They can't be told apart, so all of them are one client then, otherwise
+	// they're exempt from both.
+	UnixClientLimits bool `envconfig:"UNIX_CLIENT_LIMITS"`
+	// ConnectionQueueTimeout is how long the connections over MaxConnections wait for a slot,
+	// at most ConnectionQueueSize ones. They're rejected immediately when it's zero.
+	ConnectionQueueTimeout time.Duration `envconfig:"CONNECTION_QUEUE_TIMEOUT"`
+	ConnectionQueueSize    int           `envconfig:"CONNECTION_QUEUE_SIZE" default:"100"`
+	// ShutdownGracePeriod is how long the in-flight connections may finish on shutdown before
+	// they're closed.
+	ShutdownGracePeriod time.Duration `envconfig:"SHUTDOWN_GRACE_PERIOD" default:"10s"`
+	// Upstream is the TCP address the verified connections are spliced to instead of getting
+	// the quotes, the connections are capped by HandleConnectionTimeout until they're spliced.
+	Upstream            string        `envconfig:"UPSTREAM"`
+	UpstreamDialTimeout time.Duration `envconfig:"UPSTREAM_DIAL_TIMEOUT" default:"5s"`
 }
 
 type DdosProtector interface {
-	GenerateChallenge() (pow.Challenge, error)
-	CheckSolution(challenge pow.Challenge, nonce uint64) (bool, error)
+	GenerateChallenge(client pow.ClientInfo) (pow.Challenge, error)
+	CheckSolution(challenge pow.Challenge, client pow.ClientInfo, nonce uint64) (bool, error)
+	// CheckStamp checks the hashcash stamp sent instead of the solution of the issued challenge.
+	CheckStamp(challenge pow.Challenge, stamp string) (bool, error)
+}
+
+// LoadReporter receives the server load signals, e.g. to adapt the challenges difficulty.
+type LoadReporter interface {
+	ConnectionAccepted()
+	ConnectionClosed()
+	VerificationFailed()
+}
+
+// ClientReporter receives the per client connection outcomes, e.g. to track the client reputation.
+type ClientReporter interface {
+	ConnectionOpened(clientAddr string)
+	VerificationSucceeded(clientAddr string)
+	VerificationFailed(clientAddr string)
+	ConnectionTimedOut(clientAddr string)
+}
+
+// AccessTokenIssuer issues the tokens letting the verified clients skip the challenge on
+// later connections.
+type AccessTokenIssuer interface {
+	IssueToken(client pow.ClientInfo) (string, time.Time, error)
+	CheckToken(token string, client pow.ClientInfo) (bool, error)
 }
 
 type WisdomQuotesGetter interface {
 	GetWisdomQuote() string
 }
 
+// ClientHello is the first message of the clients speaking the protocol version 2 and later.
+type ClientHello struct {
+	Versions []int    `json:"versions"`
+	Features []string `json:"features,omitempty"`
+	// Token is the access token presented to skip the challenge.
+	Token string `json:"token,omitempty"`
+}
+
+// ServerHello is the agreed protocol version and the features supported by both peers.
+type ServerHello struct {
+	Version  int      `json:"version"`
+	Features []string `json:"features,omitempty"`
+	// TokenAccepted is set when the challenge is skipped for the presented access token,
+	// the word of wisdom follows the hello then.
+	TokenAccepted bool `json:"token_accepted,omitempty"`
+}
+
 type PowChallenge struct {
-	Data       string `json:"data"`
-	Difficulty int    `json:"difficulty"`
+	Data string `json:"data"`
+	// Algorithm is the PoW algorithm identifier, the empty one means SHA-256.
+	Algorithm string `json:"algorithm,omitempty"`
+	// Difficulty is the required number of leading zero bits of the solution hash.
+	Difficulty int `json:"difficulty"`
+	// Target is the hex encoded 256-bit target, the solution hash must be below it when it is set.
+	Target string `json:"target,omitempty"`
+	// IssuedAt, ExpiresAt and Signature are set for stateless signed challenges.
+	IssuedAt  int64  `json:"issued_at,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// Argon2 are the params of the memory-hard argon2id algorithm.
+	Argon2 *pow.Argon2Params `json:"argon2,omitempty"`
+	// HashcashResource is set when a hashcash v1 stamp for the resource may be sent
+	// instead of the solution.
+	HashcashResource string `json:"hashcash_resource,omitempty"`
 }
 
 func (pc PowChallenge) encode() ([]byte, error) {
@@ -32,8 +140,43 @@ func (pc PowChallenge) encode() ([]byte, error) {
 
 type PowChallengeSolution struct {
 	Nonce uint64 `json:"nonce"`
+	// Challenge is a signed challenge issued earlier, possibly on another connection,
+	// the solution is checked against it instead of the one sent on this connection.
+	Challenge *PowChallenge `json:"challenge,omitempty"`
+	// Stamp is the hashcash v1 stamp sent instead of the nonce.
+	Stamp string `json:"stamp,omitempty"`
 }
 
 type WordOfWisdom struct {
 	Text string `json:"text"`
+	// Token is the access token issued on the solved challenge.
+	Token          string `json:"token,omitempty"`
+	TokenExpiresAt int64  `json:"token_expires_at,omitempty"`
+}
+
+// QuoteRequest asks for one more quote within the session.
+type QuoteRequest struct{}
+
+type ErrorCode string
+
+const (
+	CodeInvalidSolution  ErrorCode = "invalid_solution"
+	CodeExpiredChallenge ErrorCode = "expired_challenge"
+	CodeRateLimited      ErrorCode = "rate_limited"
+	CodeServerOverloaded ErrorCode = "server_overloaded"
+	CodeProtocolError    ErrorCode = "protocol_error"
+	CodeInternalError    ErrorCode = "internal_error"
+	CodeSessionExpired   ErrorCode = "session_expired"
+	// CodeUpstreamUnavailable is sent to the verified client when the upstream can't be dialed.
+	CodeUpstreamUnavailable ErrorCode = "upstream_unavailable"
+)
+
+// ErrorMessage is sent to the client instead of the result when the connection is rejected.
+type ErrorMessage struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"error"`
+}
+
+func (em ErrorMessage) Error() string {
+	return fmt.Sprintf("server error %v: %v", em.Code, em.Message)
 }
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+)
+
+// UpstreamStats are the counters of the connections spliced to the upstream. The bytes are
+// counted as they're copied, so the active connections are accounted too.
+type UpstreamStats struct {
+	Connections int64
+	Active      int64
+	// BytesSent are copied from the clients to the upstream, BytesReceived the other way.
+	BytesSent     int64
+	BytesReceived int64
+}
+
+type upstreamCounters struct {
+	connections   atomic.Int64
+	active        atomic.Int64
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+}
+
+// UpstreamStats returns the counters of the connections spliced to the upstream since the server start.
+func (s *Server) UpstreamStats() UpstreamStats {
+	return UpstreamStats{
+		Connections:   s.upstreamStats.connections.Load(),
+		Active:        s.upstreamStats.active.Load(),
+		BytesSent:     s.upstreamStats.bytesSent.Load(),
+		BytesReceived: s.upstreamStats.bytesReceived.Load(),
+	}
+}
+
+func (s *Server) upstreamEnabled() bool {
+	return s.cfg.Upstream != ""
+}
+
+// spliceUpstream dials the upstream for the verified client and sends the client the result
+// without the quote, the connection is spliced to the upstream then. The data is copied in both
+// directions until both peers close their write sides or either one fails.
+func (s *Server) spliceUpstream(conn *timeoutConn, proto connProtocol, client pow.ClientInfo, wow WordOfWisdom) error {
+	upstream, err := net.DialTimeout("tcp", s.cfg.Upstream, s.cfg.UpstreamDialTimeout)
+	if err != nil {
+		s.sendError(proto, ErrorMessage{Code: CodeUpstreamUnavailable, Message: errorTexts[CodeUpstreamUnavailable]})
+		return fmt.Errorf("dial upstream error: %w", err)
+	}
+	defer upstream.Close()
+
+	if err := proto.codec.Encode(wow); err != nil {
+		return fmt.Errorf("write result to connection error: %w", err)
+	}
+
+	// The spliced connection lasts as long as the peers keep it, only the shutdown closes it.
+	if err := conn.clearTimeouts(); err != nil {
+		return fmt.Errorf("clear connection deadline error: %w", err)
+	}
+
+	logger := s.logger.With("client_addr", client.Addr, "upstream_addr", upstream.RemoteAddr())
+	logger.Info("connection spliced to upstream")
+
+	s.upstreamStats.connections.Add(1)
+	s.upstreamStats.active.Add(1)
+	defer s.upstreamStats.active.Add(-1)
+
+	// The failed copy closes both connections to stop the copy in the other direction.
+	copyHalf := func(dst net.Conn, src io.Reader, counter *atomic.Int64) (int64, error) {
+		n, err := io.Copy(countingWriter{w: dst, n: counter}, src)
+		if err == nil {
+			err = closeWrite(dst)
+		}
+		if err != nil {
+			conn.Close()
+			upstream.Close()
+		}
+		return n, err
+	}
+
+	var (
+		received   int64
+		receiveErr error
+		wg         sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		received, receiveErr = copyHalf(conn, upstream, &s.upstreamStats.bytesReceived)
+	}()
+
+	// The client data buffered with the protocol messages goes first.
+	sent, sendErr := copyHalf(upstream, io.MultiReader(proto.codec.Buffered(), proto.reader), &s.upstreamStats.bytesSent)
+	wg.Wait()
+
+	logger.Info("upstream connection closed", "bytes_sent", sent, "bytes_received", received)
+
+	if err := errors.Join(spliceError(sendErr), spliceError(receiveErr)); err != nil {
+		return fmt.Errorf("splice upstream error: %w", err)
+	}
+	return nil
+}
+
+// spliceError drops the errors of the connections closed after the copy in the other
+// direction failed or on shutdown.
+func spliceError(err error) error {
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// closeWrite shuts down the write side of the connection, so the peer reads EOF while it still
+// may send data. The connections which can't be half-closed are closed.
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return conn.Close()
+}
+
+// countingWriter adds the written bytes to the counter as they're copied.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (w countingWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.n.Add(int64(n))
+	return n, err
+}
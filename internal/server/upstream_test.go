@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+	mocks "github.com/nikvakhrameev/pow_tcp_server/mocks/internal_/server"
+)
+
+const upstreamGreeting = "+OK upstream ready\r\n"
+
+// startUpstream serves the upstream which greets the client, reads its data until EOF,
+// echoes it back and closes the connection.
+func startUpstream(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := io.WriteString(conn, upstreamGreeting); err != nil {
+					return
+				}
+				data, err := io.ReadAll(conn)
+				if err != nil {
+					return
+				}
+				_, _ = conn.Write(append([]byte("echo: "), data...))
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func serveUpstream(t *testing.T, upstream string) (*Server, string) {
+	mockDdosProtector := mocks.NewDdosProtector(t)
+	mockDdosProtector.On("GenerateChallenge", mock.Anything).
+		Return(pow.Challenge{Data: "test_data", Difficulty: 10}, nil).Maybe()
+	mockDdosProtector.On("CheckSolution", mock.Anything, mock.Anything, uint64(42)).Return(true, nil).Maybe()
+
+	srv := NewServer(
+		Config{
+			Upstream:                upstream,
+			UpstreamDialTimeout:     time.Second,
+			ProtocolProbeTimeout:    100 * time.Millisecond,
+			HandleConnectionTimeout: 10 * time.Second,
+		},
+		mockDdosProtector,
+		mocks.NewWisdomQuotesGetter(t),
+		slog.NewTextHandler(io.Discard, new(slog.HandlerOptions)),
+	)
+
+	addr, stop := startServer(t, srv)
+	t.Cleanup(func() { _ = stop() })
+
+	return srv, addr
+}
+
+// dialUpstream performs the hello exchange with the upstream feature and reads the challenge.
+func dialUpstream(t *testing.T, addr string) (net.Conn, Codec) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+
+	codec := NewJSONCodec(conn, conn, maxSolutionReadBytes)
+	require.NoError(t, codec.Encode(ClientHello{Versions: []int{ProtocolVersion2}, Features: []string{FeatureUpstream}}))
+
+	var hello ServerHello
+	require.NoError(t, codec.Decode(&hello))
+	require.Equal(t, []string{FeatureUpstream}, hello.Features)
+
+	var challenge PowChallenge
+	require.NoError(t, codec.Decode(&challenge))
+
+	return conn, codec
+}
+
+func TestServer_ServeUpstream(t *testing.T) {
+	srv, addr := serveUpstream(t, startUpstream(t))
+	conn, codec := dialUpstream(t, addr)
+
+	// The data sent right after the solution is buffered with it and forwarded first.
+	solution := new(bytes.Buffer)
+	require.NoError(t, NewJSONCodec(nil, solution, 0).Encode(PowChallengeSolution{Nonce: 42}))
+	solution.WriteString("PING\r\n")
+	_, err := conn.Write(solution.Bytes())
+	require.NoError(t, err)
+
+	var wow WordOfWisdom
+	require.NoError(t, codec.Decode(&wow))
+	require.Empty(t, wow.Text)
+
+	r := io.MultiReader(codec.Buffered(), conn)
+	greeting := make([]byte, len(upstreamGreeting))
+	_, err = io.ReadFull(r, greeting)
+	require.NoError(t, err)
+	require.Equal(t, upstreamGreeting, string(greeting))
+
+	// The upstream answers after the client closes its write side.
+	_, err = io.WriteString(conn, "QUIT\r\n")
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	echo, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "echo: PING\r\nQUIT\r\n", string(echo))
+
+	require.Eventually(t, func() bool {
+		return srv.UpstreamStats() == UpstreamStats{
+			Connections:   1,
+			BytesSent:     int64(len("PING\r\nQUIT\r\n")),
+			BytesReceived: int64(len(upstreamGreeting) + len(echo)),
+		}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_ServeUpstreamUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	upstream := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	srv, addr := serveUpstream(t, upstream)
+	_, codec := dialUpstream(t, addr)
+
+	require.NoError(t, codec.Encode(PowChallengeSolution{Nonce: 42}))
+
+	var wow WordOfWisdom
+	err = codec.Decode(&wow)
+	require.Equal(t, ErrorMessage{Code: CodeUpstreamUnavailable, Message: errorTexts[CodeUpstreamUnavailable]}, err)
+	require.Zero(t, srv.UpstreamStats().Connections)
+}
+
+func TestServer_ServeUpstreamRequiresFeature(t *testing.T) {
+	_, addr := serveUpstream(t, startUpstream(t))
+
+	conn, codec := dialWithHello(t, addr)
+	defer conn.Close()
+
+	var hello ServerHello
+	err := codec.Decode(&hello)
+	var errMsg ErrorMessage
+	require.ErrorAs(t, err, &errMsg)
+	require.Equal(t, CodeProtocolError, errMsg.Code)
+}
@@ -0,0 +1,106 @@
+// Package tlstest generates the TLS certificates for the tests.
+package tlstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Certificates are the PEM files of the CA and the certificates it signed.
+type Certificates struct {
+	CAFile string
+	// ServerCertFile is valid for localhost and 127.0.0.1.
+	ServerCertFile string
+	ServerKeyFile  string
+	ClientCertFile string
+	ClientKeyFile  string
+}
+
+// Generate writes the CA, server and client certificates to the test temp dir.
+func Generate(t testing.TB) Certificates {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	caKey := generateKey(t)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatalf("create ca certificate error: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("parse ca certificate error: %v", err)
+	}
+
+	certs := Certificates{CAFile: filepath.Join(dir, "ca.pem")}
+	writePEM(t, certs.CAFile, "CERTIFICATE", caDER)
+
+	certs.ServerCertFile, certs.ServerKeyFile = issue(t, dir, ca, caKey, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test server"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	certs.ClientCertFile, certs.ClientKeyFile = issue(t, dir, ca, caKey, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "test client"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	return certs
+}
+
+func issue(t testing.TB, dir string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, template *x509.Certificate) (string, string) {
+	template.NotBefore = ca.NotBefore
+	template.NotAfter = ca.NotAfter
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	key := generateKey(t)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatalf("create %v certificate error: %v", template.Subject.CommonName, err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal %v key error: %v", template.Subject.CommonName, err)
+	}
+
+	name := filepath.Join(dir, template.SerialNumber.String())
+	writePEM(t, name+".pem", "CERTIFICATE", der)
+	writePEM(t, name+".key", "EC PRIVATE KEY", keyDER)
+	return name + ".pem", name + ".key"
+}
+
+func generateKey(t testing.TB) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key error: %v", err)
+	}
+	return key
+}
+
+func writePEM(t testing.TB, name, blockType string, der []byte) {
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write %v error: %v", name, err)
+	}
+}
@@ -2,19 +2,49 @@ package client
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
 	"github.com/nikvakhrameev/pow_tcp_server/internal/server"
 )
 
+const (
+	ProtocolJSON   = "json"
+	ProtocolBinary = "binary"
+)
+
+// clientVersions are the protocol versions the client speaks after the hello.
+var clientVersions = []int{server.ProtocolVersion2}
+
+// maxServerMessageBytes limits the messages read from the server.
+const maxServerMessageBytes = 1 << 16
+
+// errLegacyServer is returned by the handshake when the server doesn't speak the hello.
+var errLegacyServer = fmt.Errorf("%w: server doesn't speak the hello", server.ErrIncompatibleProtocol)
+
 type Client struct {
 	cfg       Config
 	powSolver PowChallengeSolver
 	logger    *slog.Logger
+	// tlsConfig loads the TLS config on the first dial.
+	tlsConfig func() (*tls.Config, error)
+	// legacyServer is set once the server closed the connection on the hello.
+	legacyServer atomic.Bool
+
+	// mu guards the access token issued by the server on the last solved challenge.
+	mu             sync.Mutex
+	token          string
+	tokenExpiresAt time.Time
 }
 
 func NewClient(cfg Config, powSolver PowChallengeSolver, logger slog.Handler) *Client {
@@ -22,41 +52,356 @@ func NewClient(cfg Config, powSolver PowChallengeSolver, logger slog.Handler) *C
 		cfg:       cfg,
 		powSolver: powSolver,
 		logger:    slog.New(logger.WithGroup("client")),
+		tlsConfig: sync.OnceValues(func() (*tls.Config, error) {
+			return NewTLSConfig(cfg.TLS)
+		}),
 	}
 }
 
 func (c *Client) GetWordOfWisdom(ctx context.Context) (string, error) {
-	conn, err := net.Dial("tcp", c.cfg.ServerUrl)
+	conn, err := c.dial(true)
 	if err != nil {
-		return "", fmt.Errorf("dial with server error: %w", err)
+		return "", err
 	}
 	defer conn.Close()
 
-	var pc server.PowChallenge
-	if err := json.NewDecoder(conn).Decode(&pc); err != nil {
-		return "", fmt.Errorf("decode server pow challenge error: %w", err)
+	return c.verify(ctx, conn)
+}
+
+// verify solves the challenge sent on the connection and returns the first word of wisdom,
+// the challenge is skipped when the server accepted the access token. The hashcash stamp is
+// minted only when the feature is agreed, the challenge is solved otherwise.
+func (c *Client) verify(ctx context.Context, conn *serverConn) (string, error) {
+	if conn.hello.TokenAccepted {
+		c.logger.Info("access token accepted, challenge skipped")
+		return c.readWordOfWisdom(conn.codec)
+	}
+
+	challenge, err := readChallenge(conn.codec)
+	if err != nil {
+		return "", err
+	}
+
+	if c.cfg.Hashcash && challenge.HashcashResource != "" && slices.Contains(conn.hello.Features, server.FeatureHashcash) {
+		stamp, err := c.mintStamp(ctx, challenge)
+		if err != nil {
+			return "", err
+		}
+		return c.exchangeSolution(conn.codec, server.PowChallengeSolution{Stamp: stamp.String()})
+	}
+
+	nonce, err := c.solveChallenge(ctx, challenge)
+	if err != nil {
+		return "", err
+	}
+
+	return c.exchangeSolution(conn.codec, server.PowChallengeSolution{Nonce: nonce})
+}
+
+// SolveChallenge receives a challenge from the server and solves it without redeeming.
+// Signed challenges may be redeemed later on another connection with RedeemSolution.
+// The access token isn't presented, the challenge would be skipped for it.
+func (c *Client) SolveChallenge(ctx context.Context) (pow.Challenge, uint64, error) {
+	conn, err := c.dial(false)
+	if err != nil {
+		return pow.Challenge{}, 0, err
+	}
+	defer conn.Close()
+
+	challenge, err := readChallenge(conn.codec)
+	if err != nil {
+		return pow.Challenge{}, 0, err
+	}
+
+	nonce, err := c.solveChallenge(ctx, challenge)
+	if err != nil {
+		return pow.Challenge{}, 0, err
+	}
+
+	return challenge, nonce, nil
+}
+
+// RedeemSolution exchanges a solution of the signed challenge got with SolveChallenge for
+// the word of wisdom, the fresh challenge issued on this connection is ignored. The server
+// must agree the redeem feature in the hello.
+func (c *Client) RedeemSolution(ctx context.Context, challenge pow.Challenge, nonce uint64) (string, error) {
+	conn, err := c.dial(false)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if !slices.Contains(conn.hello.Features, server.FeatureRedeem) {
+		return "", fmt.Errorf("%w: server doesn't redeem solutions", server.ErrIncompatibleProtocol)
 	}
 
-	logger := c.logger.With("pow_data", pc.Data, "pow_difficulty", pc.Difficulty)
+	if _, err := readChallenge(conn.codec); err != nil {
+		return "", err
+	}
+
+	pc := server.PowChallenge(challenge)
+	return c.exchangeSolution(conn.codec, server.PowChallengeSolution{Nonce: nonce, Challenge: &pc})
+}
+
+func (c *Client) solveChallenge(ctx context.Context, challenge pow.Challenge) (uint64, error) {
+	logger := c.logger.With(
+		"pow_data", challenge.Data,
+		"pow_algorithm", challenge.GetAlgorithm(),
+		"pow_difficulty", challenge.Difficulty,
+		"pow_target", challenge.Target,
+	)
 	logger.Info("got pow challenge")
 
-	nonce, err := c.powSolver.SolvePowChallenge(ctx, pow.Challenge(pc))
+	if !c.powSolver.SupportsAlgorithm(challenge.Algorithm) {
+		return 0, fmt.Errorf("%w: server requested %q", pow.ErrUnsupportedAlgorithm, challenge.GetAlgorithm())
+	}
+
+	nonce, err := c.powSolver.SolvePowChallenge(ctx, challenge)
 	if err != nil {
-		return "", fmt.Errorf("solve pow challenge error: %w", err)
+		return 0, fmt.Errorf("solve pow challenge error: %w", err)
 	}
 
 	logger.Info("challenge solved", "nonce", nonce)
 
-	if err := json.NewEncoder(conn).Encode(
-		server.PowChallengeSolution{Nonce: nonce},
-	); err != nil {
+	return nonce, nil
+}
+
+// mintStamp mints the hashcash v1 stamp for the server resource instead of solving the challenge.
+func (c *Client) mintStamp(ctx context.Context, challenge pow.Challenge) (pow.HashcashStamp, error) {
+	logger := c.logger.With("hashcash_resource", challenge.HashcashResource, "pow_difficulty", challenge.Difficulty)
+	logger.Info("got hashcash resource")
+
+	if challenge.Target != "" {
+		return pow.HashcashStamp{}, fmt.Errorf("%w: hashcash stamps need the leading zero bits difficulty",
+			pow.ErrUnsupportedAlgorithm)
+	}
+
+	stamp, err := pow.MintHashcashStamp(ctx, challenge.HashcashResource, challenge.Difficulty, time.Now())
+	if err != nil {
+		return pow.HashcashStamp{}, fmt.Errorf("mint hashcash stamp error: %w", err)
+	}
+
+	logger.Info("hashcash stamp minted", "stamp", stamp.String())
+
+	return stamp, nil
+}
+
+// serverConn is the connection with the agreed protocol.
+type serverConn struct {
+	net.Conn
+	codec server.Codec
+	// hello is the agreed protocol version and features, it's empty when the handshake is skipped.
+	hello server.ServerHello
+	// peeked is the byte read to tell the server hello from the challenge, it's read first.
+	peeked []byte
+}
+
+func (c *serverConn) Read(b []byte) (int, error) {
+	if len(c.peeked) > 0 {
+		n := copy(b, c.peeked)
+		c.peeked = c.peeked[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// dial connects to the server and starts the configured protocol, the features are advertised
+// to the server in addition to the ones supported on every connection. The cached access token
+// is presented when presentToken is set.
+func (c *Client) dial(presentToken bool, features ...string) (*serverConn, error) {
+	netConn, err := c.dialServer()
+	if err != nil {
+		return nil, err
+	}
+	conn := &serverConn{Conn: netConn}
+
+	switch c.cfg.Protocol {
+	case "", ProtocolJSON:
+		conn.codec = server.NewJSONCodec(conn, conn, maxServerMessageBytes)
+	case ProtocolBinary:
+		if _, err := conn.Write([]byte{server.BinaryMagic}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("write binary protocol magic error: %w", err)
+		}
+		conn.codec = server.NewBinaryCodec(conn, conn, maxServerMessageBytes)
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("unknown protocol %q", c.cfg.Protocol)
+	}
+
+	if !c.cfg.SkipHandshake && !c.legacyServer.Load() {
+		if err := c.handshake(conn, presentToken, features); err != nil {
+			conn.Close()
+			// The binary protocol isn't spoken by the legacy servers at all.
+			if errors.Is(err, errLegacyServer) && c.cfg.Protocol != ProtocolBinary {
+				c.logger.Warn("server doesn't speak the hello, fall back to the legacy exchange")
+				c.legacyServer.Store(true)
+				return c.dial(presentToken, features...)
+			}
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// dialServer connects to the server over TLS when it's enabled. The server url with the
+// unix: prefix is the unix socket path.
+func (c *Client) dialServer() (net.Conn, error) {
+	network, addr := "tcp", c.cfg.ServerUrl
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+
+	if !c.cfg.TLS.Enabled {
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("dial with server error: %w", err)
+		}
+		return conn, nil
+	}
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("create tls config error: %w", err)
+	}
+	conn, err := tls.Dial(network, addr, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("dial with server over tls error: %w", err)
+	}
+	return conn, nil
+}
+
+// handshake agrees the protocol version and features with the server.
+func (c *Client) handshake(conn *serverConn, presentToken bool, features []string) error {
+	features = append([]string{server.FeatureRedeem, server.FeatureAccessToken}, features...)
+	if c.cfg.Hashcash {
+		features = append(features, server.FeatureHashcash)
+	}
+
+	var token string
+	if presentToken {
+		token = c.accessToken()
+	}
+
+	hello := server.ClientHello{Versions: clientVersions, Features: features, Token: token}
+	if err := conn.codec.Encode(hello); err != nil {
+		return fmt.Errorf("write client hello error: %w", err)
+	}
+
+	if c.cfg.Protocol == ProtocolBinary {
+		if err := skipEarlyChallenge(conn); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.codec.Decode(&conn.hello); err != nil {
+		return fmt.Errorf("read server hello error: %w", serverError(err))
+	}
+
+	// The server hello always has the version, the challenge has none. The server which doesn't
+	// probe for the hello sends the challenge at once, the hello follows it. The legacy server
+	// takes the hello for the wrong solution and closes the connection instead.
+	if conn.hello.Version == 0 {
+		conn.hello = server.ServerHello{}
+		if err := conn.codec.Decode(&conn.hello); err != nil {
+			var serverErr *ServerError
+			if err := serverError(err); errors.As(err, &serverErr) {
+				return fmt.Errorf("read server hello error: %w", err)
+			}
+			return errLegacyServer
+		}
+		if conn.hello.Version == 0 {
+			return errLegacyServer
+		}
+	}
+
+	if !slices.Contains(clientVersions, conn.hello.Version) {
+		return fmt.Errorf("%w: server selected version %v", server.ErrIncompatibleProtocol, conn.hello.Version)
+	}
+
+	if token != "" && !conn.hello.TokenAccepted {
+		c.logger.Info("access token rejected, drop it")
+		c.setAccessToken(token, "", 0)
+	}
+
+	c.logger.Info("protocol negotiated", "version", conn.hello.Version, "features", conn.hello.Features)
+
+	return nil
+}
+
+// skipEarlyChallenge skips the JSON challenge the server which doesn't probe for the hello
+// sends before the binary frames. It's read byte by byte, so no frame following it is buffered.
+func skipEarlyChallenge(conn *serverConn) error {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(conn.Conn, b); err != nil {
+		return fmt.Errorf("read server hello error: %w", err)
+	}
+	if b[0] != '{' {
+		conn.peeked = b
+		return nil
+	}
+
+	for n := 1; b[0] != '\n'; n++ {
+		if n > maxServerMessageBytes {
+			return fmt.Errorf("read server challenge error: %w", server.ErrMessageTooLarge)
+		}
+		if _, err := io.ReadFull(conn.Conn, b); err != nil {
+			return fmt.Errorf("read server challenge error: %w", err)
+		}
+	}
+	return nil
+}
+
+func readChallenge(codec server.Codec) (pow.Challenge, error) {
+	var pc server.PowChallenge
+	if err := codec.Decode(&pc); err != nil {
+		return pow.Challenge{}, fmt.Errorf("decode server pow challenge error: %w", serverError(err))
+	}
+	return pow.Challenge(pc), nil
+}
+
+func (c *Client) exchangeSolution(codec server.Codec, solution server.PowChallengeSolution) (string, error) {
+	if err := codec.Encode(solution); err != nil {
 		return "", fmt.Errorf("encode pow challenge solution errror: %w", err)
 	}
 
+	return c.readWordOfWisdom(codec)
+}
+
+// readWordOfWisdom reads the word of wisdom and caches the access token issued with it.
+func (c *Client) readWordOfWisdom(codec server.Codec) (string, error) {
 	var res server.WordOfWisdom
-	if err := json.NewDecoder(conn).Decode(&res); err != nil {
-		return "", fmt.Errorf("read word of wisdom error: %w", err)
+	if err := codec.Decode(&res); err != nil {
+		return "", fmt.Errorf("read word of wisdom error: %w", serverError(err))
+	}
+
+	if res.Token != "" {
+		c.setAccessToken("", res.Token, res.TokenExpiresAt)
 	}
 
 	return res.Text, nil
 }
+
+// accessToken returns the cached access token unless it's expired.
+func (c *Client) accessToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == "" || !time.Now().Before(c.tokenExpiresAt) {
+		return ""
+	}
+	return c.token
+}
+
+// setAccessToken replaces the cached token, the old one is compared so the token issued
+// concurrently isn't dropped.
+func (c *Client) setAccessToken(old, token string, expiresAt int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old != "" && c.token != old {
+		return
+	}
+	c.token, c.tokenExpiresAt = token, time.Unix(expiresAt, 0)
+}
@@ -0,0 +1,278 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+	"github.com/nikvakhrameev/pow_tcp_server/internal/server"
+	"github.com/nikvakhrameev/pow_tcp_server/internal/wisdom"
+	mocks "github.com/nikvakhrameev/pow_tcp_server/mocks/internal_/server"
+)
+
+var testLogger = slog.NewTextHandler(io.Discard, new(slog.HandlerOptions))
+
+func newTestChallenger(opts ...pow.ChallengerOption) *pow.Challenger {
+	return pow.NewChallenger(
+		pow.NewStaticDifficulty(8),
+		pow.NewRandomDataGenerator(sha256.Size),
+		pow.NewSha256Hasher(),
+		opts...,
+	)
+}
+
+// startServer serves the quotes on the loopback listener until the test ends and returns its address.
+func startServer(t *testing.T, cfg server.Config, protector server.DdosProtector, opts ...server.Option) string {
+	return startQuotesServer(t, cfg, protector, wisdom.NewQuotesStorage(), opts...)
+}
+
+func startQuotesServer(
+	t *testing.T,
+	cfg server.Config,
+	protector server.DdosProtector,
+	quotes server.WisdomQuotesGetter,
+	opts ...server.Option,
+) string {
+	srv := server.NewServer(cfg, protector, quotes, testLogger, opts...)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErrChan := make(chan error, 1)
+	go func() {
+		serveErrChan <- srv.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		require.ErrorIs(t, <-serveErrChan, context.Canceled)
+	})
+
+	return listener.Addr().String()
+}
+
+// startLegacyServer serves the exchange of the servers predating the hello until the test ends
+// and returns its address.
+func startLegacyServer(t *testing.T, challenger *pow.Challenger) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	client := pow.ClientInfo{Addr: "legacy"}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				challenge, err := challenger.GenerateChallenge(client)
+				if err != nil {
+					return
+				}
+				if err := json.NewEncoder(conn).Encode(server.PowChallenge(challenge)); err != nil {
+					return
+				}
+
+				// The legacy server reads at most 32 bytes of the solution.
+				var solution server.PowChallengeSolution
+				if err := json.NewDecoder(io.LimitReader(conn, 32)).Decode(&solution); err != nil {
+					return
+				}
+				if ok, err := challenger.CheckSolution(challenge, client, solution.Nonce); err != nil || !ok {
+					return
+				}
+				_ = json.NewEncoder(conn).Encode(server.WordOfWisdom{Text: "legacy quote"})
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestClient_LegacyServerFallback(t *testing.T) {
+	challenger := newTestChallenger()
+	addr := startLegacyServer(t, challenger)
+
+	cli := NewClient(Config{ServerUrl: addr}, challenger, testLogger)
+
+	for i := 0; i < 2; i++ {
+		res, err := cli.GetWordOfWisdom(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "legacy quote", res)
+	}
+	require.True(t, cli.legacyServer.Load())
+
+	_, err := NewClient(Config{ServerUrl: addr, Protocol: ProtocolBinary}, challenger, testLogger).
+		GetWordOfWisdom(context.Background())
+	require.Error(t, err)
+}
+
+func TestClient_ServerWithoutProbe(t *testing.T) {
+	for _, protocol := range []string{ProtocolJSON, ProtocolBinary} {
+		t.Run(protocol, func(t *testing.T) {
+			protector := &countingProtector{Challenger: newTestChallenger()}
+
+			// The hello is read in place of the solution, the client isn't reported as failed.
+			reporter := mocks.NewClientReporter(t)
+			reporter.On("ConnectionOpened", mock.Anything).Return().Twice()
+			reporter.On("VerificationSucceeded", mock.Anything).Return().Twice()
+
+			addr := startServer(t, server.Config{}, protector, server.WithClientReporter(reporter))
+
+			cli := NewClient(Config{ServerUrl: addr, Protocol: protocol}, protector.Challenger, testLogger)
+
+			for i := 0; i < 2; i++ {
+				res, err := cli.GetWordOfWisdom(context.Background())
+				require.NoError(t, err)
+				require.NotEmpty(t, res)
+			}
+			require.False(t, cli.legacyServer.Load())
+			require.Equal(t, int32(2), protector.challenges.Load())
+		})
+	}
+}
+
+// rejectingProtector rejects every solution.
+type rejectingProtector struct {
+	*pow.Challenger
+}
+
+func (rejectingProtector) CheckSolution(pow.Challenge, pow.ClientInfo, uint64) (bool, error) {
+	return false, nil
+}
+
+func TestClient_ServerErrorsWithDefaultConfig(t *testing.T) {
+	for _, protocol := range []string{ProtocolJSON, ProtocolBinary} {
+		t.Run(protocol, func(t *testing.T) {
+			challenger := newTestChallenger()
+
+			addr := startServer(t, server.Config{}, rejectingProtector{Challenger: challenger})
+			_, err := NewClient(Config{ServerUrl: addr, Protocol: protocol}, challenger, testLogger).
+				GetWordOfWisdom(context.Background())
+			require.ErrorIs(t, err, ErrInvalidSolution)
+
+			// The connection over the limit is rejected with the error message too.
+			addr = startServer(t, server.Config{MaxConnections: 1}, challenger)
+			conn, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = readChallenge(server.NewJSONCodec(conn, conn, maxServerMessageBytes))
+			require.NoError(t, err)
+
+			_, err = NewClient(Config{ServerUrl: addr, Protocol: protocol}, challenger, testLogger).
+				GetWordOfWisdom(context.Background())
+			require.ErrorIs(t, err, ErrServerOverloaded)
+		})
+	}
+}
+
+func TestClient_SkipHandshakeFeatures(t *testing.T) {
+	challenger := newTestChallenger(
+		pow.WithHmacSigning([]byte("secret"), time.Minute),
+		pow.WithReplayCache(pow.NewShardedReplayCache(1000)),
+		pow.WithHashcash("pow.test", time.Minute),
+	)
+	addr := startServer(t, server.Config{}, challenger)
+
+	cli := NewClient(Config{ServerUrl: addr, SkipHandshake: true, Hashcash: true}, challenger, testLogger)
+
+	// The stamp isn't minted without the agreed feature, the challenge is solved instead.
+	res, err := cli.GetWordOfWisdom(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, res)
+
+	challenge, nonce, err := cli.SolveChallenge(context.Background())
+	require.NoError(t, err)
+	_, err = cli.RedeemSolution(context.Background(), challenge, nonce)
+	require.ErrorIs(t, err, server.ErrIncompatibleProtocol)
+}
+
+func TestClient_AccessToken(t *testing.T) {
+	secret := []byte("secret")
+	protector := &countingProtector{Challenger: newTestChallenger(
+		pow.WithHmacSigning(secret, time.Minute),
+		pow.WithReplayCache(pow.NewShardedReplayCache(1000)),
+	)}
+	accessTokens := pow.NewAccessTokens(
+		secret,
+		pow.AccessTokenConfig{TTL: time.Minute, MaxUses: 2},
+		pow.NewShardedReplayCache(1000),
+		time.Now,
+	)
+
+	cfg := server.Config{ProtocolProbeTimeout: 100 * time.Millisecond, HandleConnectionTimeout: 10 * time.Second}
+	addr := startServer(t, cfg, protector, server.WithAccessTokens(accessTokens))
+
+	ctx := context.Background()
+	cli := NewClient(Config{ServerUrl: addr}, protector.Challenger, testLogger)
+
+	getQuote := func() {
+		res, err := cli.GetWordOfWisdom(ctx)
+		require.NoError(t, err)
+		require.NotEmpty(t, res)
+	}
+
+	// The token is issued on the solved challenge.
+	getQuote()
+	require.Equal(t, int32(1), protector.challenges.Load())
+	token := cli.accessToken()
+	require.NotEmpty(t, token)
+
+	// The token skips the challenge.
+	getQuote()
+	require.Equal(t, int32(1), protector.challenges.Load())
+
+	// The challenges to solve and redeem are got without spending the token.
+	challenge, nonce, err := cli.SolveChallenge(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, challenge.Data)
+	res, err := cli.RedeemSolution(ctx, challenge, nonce)
+	require.NoError(t, err)
+	require.NotEmpty(t, res)
+	require.Equal(t, int32(3), protector.challenges.Load())
+
+	// The redeemed solution is rewarded with the new token.
+	require.NotEqual(t, token, cli.accessToken())
+	token = cli.accessToken()
+
+	getQuote()
+	getQuote()
+	require.Equal(t, int32(3), protector.challenges.Load())
+
+	// The used up token is dropped, the challenge is solved and the new token is issued.
+	getQuote()
+	require.Equal(t, int32(4), protector.challenges.Load())
+	require.NotEmpty(t, cli.accessToken())
+	require.NotEqual(t, token, cli.accessToken())
+}
+
+func TestClient_SetAccessToken(t *testing.T) {
+	cli := NewClient(Config{}, newTestChallenger(), testLogger)
+	require.Empty(t, cli.accessToken())
+
+	expiresAt := time.Now().Add(time.Minute).Unix()
+	cli.setAccessToken("", "token", expiresAt)
+	require.Equal(t, "token", cli.accessToken())
+
+	// The token issued concurrently isn't dropped for the rejected older one.
+	cli.setAccessToken("older", "", 0)
+	require.Equal(t, "token", cli.accessToken())
+
+	cli.setAccessToken("token", "", 0)
+	require.Empty(t, cli.accessToken())
+
+	cli.setAccessToken("", "expired", time.Now().Add(-time.Second).Unix())
+	require.Empty(t, cli.accessToken())
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/server"
+)
+
+// ErrServerRejected is matched by every ServerError, the errors below match the particular codes.
+var ErrServerRejected = errors.New("server rejected connection")
+
+var (
+	ErrInvalidSolution  = errors.New("invalid solution")
+	ErrExpiredChallenge = errors.New("expired challenge")
+	ErrRateLimited      = errors.New("rate limited")
+	ErrServerOverloaded = errors.New("server overloaded")
+	ErrProtocol         = errors.New("protocol error")
+	ErrInternal         = errors.New("internal server error")
+	ErrSessionExpired   = errors.New("session expired")
+	// ErrUpstreamUnavailable is returned by Dial when the server can't reach its upstream.
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+)
+
+var codeErrors = map[server.ErrorCode]error{
+	server.CodeInvalidSolution:     ErrInvalidSolution,
+	server.CodeExpiredChallenge:    ErrExpiredChallenge,
+	server.CodeRateLimited:         ErrRateLimited,
+	server.CodeServerOverloaded:    ErrServerOverloaded,
+	server.CodeProtocolError:       ErrProtocol,
+	server.CodeInternalError:       ErrInternal,
+	server.CodeSessionExpired:      ErrSessionExpired,
+	server.CodeUpstreamUnavailable: ErrUpstreamUnavailable,
+}
+
+// ServerError is the error message sent by the server before closing the connection.
+type ServerError struct {
+	Code    server.ErrorCode
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server rejected connection with %v: %v", e.Code, e.Message)
+}
+
+func (e *ServerError) Is(target error) bool {
+	return target == ErrServerRejected || target == codeErrors[e.Code]
+}
+
+// serverError converts the error message decoded by the codec to ServerError.
+func serverError(err error) error {
+	var errMsg server.ErrorMessage
+	if errors.As(err, &errMsg) {
+		return &ServerError{Code: errMsg.Code, Message: errMsg.Message}
+	}
+	return err
+}
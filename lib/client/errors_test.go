@@ -0,0 +1,29 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/server"
+)
+
+func TestServerError(t *testing.T) {
+	err := fmt.Errorf("read word of wisdom error: %w", serverError(server.ErrorMessage{
+		Code:    server.CodeExpiredChallenge,
+		Message: "the challenge is expired",
+	}))
+
+	require.ErrorIs(t, err, ErrServerRejected)
+	require.ErrorIs(t, err, ErrExpiredChallenge)
+	require.NotErrorIs(t, err, ErrInvalidSolution)
+
+	var serverErr *ServerError
+	require.ErrorAs(t, err, &serverErr)
+	require.Equal(t, server.CodeExpiredChallenge, serverErr.Code)
+
+	testErr := errors.New("test error")
+	require.Equal(t, testErr, serverError(testErr))
+}
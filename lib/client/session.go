@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/server"
+)
+
+// Session gets several words of wisdom for one solved challenge when the server supports
+// sessions. A new challenge is solved transparently when the session budget is spent or the
+// server doesn't support sessions. Session isn't safe for concurrent use.
+type Session struct {
+	client *Client
+	conn   *serverConn
+	// quote is the word of wisdom got on verification and not returned yet.
+	quote string
+}
+
+// NewSession solves the challenge and opens the session.
+func (c *Client) NewSession(ctx context.Context) (*Session, error) {
+	session := &Session{client: c}
+	if err := session.renew(ctx); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// maxSessionRenewals bounds the renewals within one GetWordOfWisdom call, so the server
+// expiring every renewed session doesn't loop the client.
+const maxSessionRenewals = 2
+
+func (s *Session) GetWordOfWisdom(ctx context.Context) (string, error) {
+	for renewals := 0; ; renewals++ {
+		if s.conn == nil {
+			if err := s.renew(ctx); err != nil {
+				return "", err
+			}
+		}
+
+		if s.quote != "" {
+			quote := s.quote
+			s.quote = ""
+			return quote, nil
+		}
+
+		if !slices.Contains(s.conn.hello.Features, server.FeatureSession) {
+			if renewals >= maxSessionRenewals {
+				return "", fmt.Errorf("%w: no quote got after %v renewals", ErrSessionExpired, renewals)
+			}
+			_ = s.Close()
+			continue
+		}
+
+		quote, err := s.requestQuote()
+		// The server closes the idle sessions once they're expired.
+		if errors.Is(err, ErrSessionExpired) || errors.Is(err, io.EOF) {
+			if renewals >= maxSessionRenewals {
+				return "", err
+			}
+			s.client.logger.Info("session expired, renew it")
+			_ = s.Close()
+			continue
+		}
+		return quote, err
+	}
+}
+
+func (s *Session) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// renew replaces the session connection with the new verified one.
+func (s *Session) renew(ctx context.Context) error {
+	_ = s.Close()
+
+	conn, err := s.client.dial(true, server.FeatureSession)
+	if err != nil {
+		return err
+	}
+
+	quote, err := s.client.verify(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.conn, s.quote = conn, quote
+	return nil
+}
+
+func (s *Session) requestQuote() (string, error) {
+	if err := s.conn.codec.Encode(server.QuoteRequest{}); err != nil {
+		return "", fmt.Errorf("write quote request error: %w", err)
+	}
+
+	var res server.WordOfWisdom
+	if err := s.conn.codec.Decode(&res); err != nil {
+		return "", fmt.Errorf("read word of wisdom error: %w", serverError(err))
+	}
+
+	return res.Text, nil
+}
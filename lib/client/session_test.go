@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+	"github.com/nikvakhrameev/pow_tcp_server/internal/server"
+)
+
+// countingProtector counts the generated challenges, i.e. the connections the client verified.
+type countingProtector struct {
+	*pow.Challenger
+	challenges atomic.Int32
+}
+
+func (p *countingProtector) GenerateChallenge(client pow.ClientInfo) (pow.Challenge, error) {
+	p.challenges.Add(1)
+	return p.Challenger.GenerateChallenge(client)
+}
+
+func TestSession_GetWordOfWisdom(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Config server.Config
+		Quotes int
+		// Challenges is the number of challenges the quotes cost.
+		Challenges int32
+	}{
+		{
+			Name:       "first_quote_buffered",
+			Config:     server.Config{SessionMaxQuotes: 3},
+			Quotes:     1,
+			Challenges: 1,
+		},
+		{
+			Name:       "renewed_on_budget_spent",
+			Config:     server.Config{SessionMaxQuotes: 3},
+			Quotes:     7,
+			Challenges: 3,
+		},
+		{
+			Name:       "renewed_on_session_expired",
+			Config:     server.Config{SessionMaxQuotes: 10, SessionDuration: time.Nanosecond},
+			Quotes:     3,
+			Challenges: 3,
+		},
+		{
+			Name:       "server_without_sessions",
+			Config:     server.Config{SessionMaxQuotes: 1},
+			Quotes:     3,
+			Challenges: 3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			protector := &countingProtector{Challenger: newTestChallenger()}
+
+			tc.Config.ProtocolProbeTimeout = 100 * time.Millisecond
+			tc.Config.HandleConnectionTimeout = 10 * time.Second
+			addr := startServer(t, tc.Config, protector)
+
+			cli := NewClient(Config{ServerUrl: addr}, protector.Challenger, testLogger)
+
+			session, err := cli.NewSession(context.Background())
+			require.NoError(t, err)
+			defer session.Close()
+
+			for i := 0; i < tc.Quotes; i++ {
+				quote, err := session.GetWordOfWisdom(context.Background())
+				require.NoError(t, err)
+				require.NotEmpty(t, quote)
+			}
+
+			require.Equal(t, tc.Challenges, protector.challenges.Load())
+		})
+	}
+}
+
+type emptyQuotes struct{}
+
+func (emptyQuotes) GetWisdomQuote() string { return "" }
+
+func TestSession_GetWordOfWisdomRenewalsBounded(t *testing.T) {
+	protector := &countingProtector{Challenger: newTestChallenger()}
+
+	// Every renewed session is expired before the quote is got.
+	cfg := server.Config{
+		SessionMaxQuotes:        10,
+		SessionDuration:         time.Nanosecond,
+		ProtocolProbeTimeout:    100 * time.Millisecond,
+		HandleConnectionTimeout: 10 * time.Second,
+	}
+	addr := startQuotesServer(t, cfg, protector, emptyQuotes{})
+
+	cli := NewClient(Config{ServerUrl: addr}, protector.Challenger, testLogger)
+
+	session, err := cli.NewSession(context.Background())
+	require.NoError(t, err)
+	defer session.Close()
+
+	_, err = session.GetWordOfWisdom(context.Background())
+	require.ErrorIs(t, err, ErrSessionExpired)
+	require.Equal(t, int32(1+maxSessionRenewals), protector.challenges.Load())
+}
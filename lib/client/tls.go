@@ -0,0 +1,51 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/server"
+)
+
+type TLSConfig struct {
+	Enabled bool `envconfig:"ENABLED"`
+	// CAFile is the PEM encoded CAs the server certificate is verified with, the system
+	// roots are used when it's empty.
+	CAFile string `envconfig:"CA_FILE"`
+	// ServerName overrides the server name verified, it's the server url host by default.
+	ServerName string `envconfig:"SERVER_NAME"`
+	// CertFile and KeyFile are the PEM encoded client certificate and key, the servers
+	// trusting it may ask for easier challenges.
+	CertFile   string `envconfig:"CERT_FILE"`
+	KeyFile    string `envconfig:"KEY_FILE"`
+	MinVersion string `envconfig:"MIN_VERSION" default:"1.2"`
+}
+
+// NewTLSConfig returns the client TLS config.
+func NewTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	minVersion, err := server.ParseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: cfg.ServerName,
+		MinVersion: minVersion,
+	}
+
+	if cfg.CAFile != "" {
+		if tlsConfig.RootCAs, err = server.LoadCertPool(cfg.CAFile); err != nil {
+			return nil, fmt.Errorf("load server CAs error: %w", err)
+		}
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate error: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
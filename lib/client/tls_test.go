@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+	"github.com/nikvakhrameev/pow_tcp_server/internal/server"
+	"github.com/nikvakhrameev/pow_tcp_server/internal/tlstest"
+	"github.com/nikvakhrameev/pow_tcp_server/internal/wisdom"
+)
+
+func TestClient_GetWordOfWisdomTLS(t *testing.T) {
+	certs := tlstest.Generate(t)
+
+	// The untrusted clients can't solve the challenges within the test.
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(64),
+		pow.NewRandomDataGenerator(sha256.Size),
+		pow.NewSha256Hasher(),
+		pow.WithTrustedClientDifficulty(0),
+	)
+	serverCfg := server.Config{
+		TLS: server.TLSConfig{
+			CertFile:     certs.ServerCertFile,
+			KeyFile:      certs.ServerKeyFile,
+			ClientCAFile: certs.CAFile,
+		},
+		ProtocolProbeTimeout:    100 * time.Millisecond,
+		HandleConnectionTimeout: 10 * time.Second,
+	}
+	logger := slog.NewTextHandler(io.Discard, new(slog.HandlerOptions))
+	srv := server.NewServer(serverCfg, challenger, wisdom.NewQuotesStorage(), logger)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErrChan := make(chan error, 1)
+	go func() {
+		serveErrChan <- srv.Serve(ctx, listener)
+	}()
+	defer func() {
+		cancel()
+		require.ErrorIs(t, <-serveErrChan, context.Canceled)
+	}()
+
+	cfg := Config{
+		ServerUrl: listener.Addr().String(),
+		TLS: TLSConfig{
+			Enabled:    true,
+			CAFile:     certs.CAFile,
+			ServerName: "localhost",
+			CertFile:   certs.ClientCertFile,
+			KeyFile:    certs.ClientKeyFile,
+		},
+	}
+
+	cli := NewClient(cfg, challenger, logger)
+	res, err := cli.GetWordOfWisdom(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, res)
+
+	cfg.TLS.CAFile = ""
+	_, err = NewClient(cfg, challenger, logger).GetWordOfWisdom(ctx)
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	require.ErrorAs(t, err, &unknownAuthorityErr)
+
+	cfg.TLS.MinVersion = "1.4"
+	_, err = NewClient(cfg, challenger, logger).GetWordOfWisdom(ctx)
+	require.ErrorContains(t, err, "unknown tls version")
+}
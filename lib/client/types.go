@@ -8,8 +8,20 @@ import (
 
 type Config struct {
 	ServerUrl string `envconfig:"SERVER_URL" default:"localhost:8085"`
+	// Protocol is the wire protocol, json or binary.
+	Protocol string `envconfig:"PROTOCOL" default:"json"`
+	// SkipHandshake disables the hello exchange for the servers predating it. Without it the
+	// JSON client falls back to the legacy exchange for the rest of its life once such a server
+	// closes the connection on the hello, the server takes the hello for the wrong solution.
+	SkipHandshake bool `envconfig:"SKIP_HANDSHAKE"`
+	// Hashcash makes the client mint hashcash v1 stamps when the server accepts them.
+	Hashcash bool      `envconfig:"HASHCASH"`
+	TLS      TLSConfig `envconfig:"TLS"`
 }
 
 type PowChallengeSolver interface {
 	SolvePowChallenge(ctx context.Context, pow pow.Challenge) (uint64, error)
+	// SupportsAlgorithm reports whether the challenges of the algorithm can be solved,
+	// the client refuses the other ones.
+	SupportsAlgorithm(name string) bool
 }
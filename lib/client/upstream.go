@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/server"
+)
+
+// Dial solves the challenge of the server in the reverse proxy mode and returns the connection
+// spliced to its upstream. The connection is closed by the caller.
+func (c *Client) Dial(ctx context.Context) (net.Conn, error) {
+	conn, err := c.dial(true, server.FeatureUpstream)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(conn.hello.Features, server.FeatureUpstream) {
+		conn.Close()
+		return nil, fmt.Errorf("%w: server doesn't splice connections to upstream", server.ErrIncompatibleProtocol)
+	}
+
+	if _, err := c.verify(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c.logger.Info("connection spliced to upstream")
+
+	return &upstreamConn{Conn: conn.Conn, r: io.MultiReader(conn.codec.Buffered(), conn.Conn)}, nil
+}
+
+// upstreamConn is the connection spliced to the upstream, the upstream data buffered with the
+// result is read first.
+type upstreamConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *upstreamConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// CloseWrite shuts down the write side of the connection, so the upstream reads EOF.
+func (c *upstreamConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
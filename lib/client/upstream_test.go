@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikvakhrameev/pow_tcp_server/internal/pow"
+	"github.com/nikvakhrameev/pow_tcp_server/internal/server"
+	"github.com/nikvakhrameev/pow_tcp_server/internal/wisdom"
+)
+
+func TestClient_Dial(t *testing.T) {
+	// The upstream greets the client and echoes its data once the client closes the write side.
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, "hello\n")
+		data, _ := io.ReadAll(conn)
+		_, _ = conn.Write(data)
+	}()
+
+	challenger := pow.NewChallenger(
+		pow.NewStaticDifficulty(8),
+		pow.NewRandomDataGenerator(sha256.Size),
+		pow.NewSha256Hasher(),
+	)
+	serverCfg := server.Config{
+		Upstream:                upstream.Addr().String(),
+		UpstreamDialTimeout:     time.Second,
+		ProtocolProbeTimeout:    100 * time.Millisecond,
+		HandleConnectionTimeout: 10 * time.Second,
+	}
+	logger := slog.NewTextHandler(io.Discard, new(slog.HandlerOptions))
+	srv := server.NewServer(serverCfg, challenger, wisdom.NewQuotesStorage(), logger)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErrChan := make(chan error, 1)
+	go func() {
+		serveErrChan <- srv.Serve(ctx, listener)
+	}()
+	defer func() {
+		cancel()
+		require.ErrorIs(t, <-serveErrChan, context.Canceled)
+	}()
+
+	cli := NewClient(Config{ServerUrl: listener.Addr().String()}, challenger, logger)
+
+	_, err = cli.GetWordOfWisdom(ctx)
+	require.ErrorIs(t, err, ErrProtocol)
+
+	conn, err := cli.Dial(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+
+	_, err = io.WriteString(conn, "ping")
+	require.NoError(t, err)
+	require.NoError(t, conn.(interface{ CloseWrite() error }).CloseWrite())
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "hello\nping", string(data))
+}
@@ -12,23 +12,23 @@ type DdosProtector struct {
 	mock.Mock
 }
 
-// CheckSolution provides a mock function with given fields: challenge, nonce
-func (_m *DdosProtector) CheckSolution(challenge pow.Challenge, nonce uint64) (bool, error) {
-	ret := _m.Called(challenge, nonce)
+// CheckSolution provides a mock function with given fields: challenge, client, nonce
+func (_m *DdosProtector) CheckSolution(challenge pow.Challenge, client pow.ClientInfo, nonce uint64) (bool, error) {
+	ret := _m.Called(challenge, client, nonce)
 
 	var r0 bool
 	var r1 error
-	if rf, ok := ret.Get(0).(func(pow.Challenge, uint64) (bool, error)); ok {
-		return rf(challenge, nonce)
+	if rf, ok := ret.Get(0).(func(pow.Challenge, pow.ClientInfo, uint64) (bool, error)); ok {
+		return rf(challenge, client, nonce)
 	}
-	if rf, ok := ret.Get(0).(func(pow.Challenge, uint64) bool); ok {
-		r0 = rf(challenge, nonce)
+	if rf, ok := ret.Get(0).(func(pow.Challenge, pow.ClientInfo, uint64) bool); ok {
+		r0 = rf(challenge, client, nonce)
 	} else {
 		r0 = ret.Get(0).(bool)
 	}
 
-	if rf, ok := ret.Get(1).(func(pow.Challenge, uint64) error); ok {
-		r1 = rf(challenge, nonce)
+	if rf, ok := ret.Get(1).(func(pow.Challenge, pow.ClientInfo, uint64) error); ok {
+		r1 = rf(challenge, client, nonce)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -36,23 +36,47 @@ func (_m *DdosProtector) CheckSolution(challenge pow.Challenge, nonce uint64) (b
 	return r0, r1
 }
 
-// GenerateChallenge provides a mock function with given fields:
-func (_m *DdosProtector) GenerateChallenge() (pow.Challenge, error) {
-	ret := _m.Called()
+// CheckStamp provides a mock function with given fields: challenge, stamp
+func (_m *DdosProtector) CheckStamp(challenge pow.Challenge, stamp string) (bool, error) {
+	ret := _m.Called(challenge, stamp)
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(pow.Challenge, string) (bool, error)); ok {
+		return rf(challenge, stamp)
+	}
+	if rf, ok := ret.Get(0).(func(pow.Challenge, string) bool); ok {
+		r0 = rf(challenge, stamp)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(pow.Challenge, string) error); ok {
+		r1 = rf(challenge, stamp)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GenerateChallenge provides a mock function with given fields: client
+func (_m *DdosProtector) GenerateChallenge(client pow.ClientInfo) (pow.Challenge, error) {
+	ret := _m.Called(client)
 
 	var r0 pow.Challenge
 	var r1 error
-	if rf, ok := ret.Get(0).(func() (pow.Challenge, error)); ok {
-		return rf()
+	if rf, ok := ret.Get(0).(func(pow.ClientInfo) (pow.Challenge, error)); ok {
+		return rf(client)
 	}
-	if rf, ok := ret.Get(0).(func() pow.Challenge); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(pow.ClientInfo) pow.Challenge); ok {
+		r0 = rf(client)
 	} else {
 		r0 = ret.Get(0).(pow.Challenge)
 	}
 
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(pow.ClientInfo) error); ok {
+		r1 = rf(client)
 	} else {
 		r1 = ret.Error(1)
 	}
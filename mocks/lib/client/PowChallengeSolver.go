@@ -38,6 +38,20 @@ func (_m *PowChallengeSolver) SolvePowChallenge(ctx context.Context, _a1 pow.Cha
 	return r0, r1
 }
 
+// SupportsAlgorithm provides a mock function with given fields: name
+func (_m *PowChallengeSolver) SupportsAlgorithm(name string) bool {
+	ret := _m.Called(name)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(name)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
 type mockConstructorTestingTNewPowChallengeSolver interface {
 	mock.TestingT
 	Cleanup(func())